@@ -0,0 +1,118 @@
+package sse
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Backoff decides how long an HttpReceiver waits before each reconnection
+// attempt. A receiver calls it from one goroutine at a time, so
+// implementations may keep state without locking, but a Backoff must not be
+// shared between receivers.
+type Backoff interface {
+	// Next returns the delay before the given reconnection attempt,
+	// counting from 1. retry is the reconnection time most recently sent by
+	// the server in a retry field, or zero if it has sent none.
+	Next(attempt int, retry time.Duration) time.Duration
+	// Reset is called when a connection that stayed healthy ends, so the
+	// following outage starts from the shortest delay.
+	Reset()
+}
+
+type constantBackoff struct {
+	delay time.Duration
+}
+
+// NewConstantBackoff waits the same delay before every attempt. A retry
+// value sent by the server replaces the delay, as browsers do.
+func NewConstantBackoff(delay time.Duration) Backoff {
+	return &constantBackoff{delay: delay}
+}
+
+func (b *constantBackoff) Next(attempt int, retry time.Duration) time.Duration {
+	if retry > 0 {
+		return retry
+	}
+	return b.delay
+}
+
+func (b *constantBackoff) Reset() {}
+
+type exponentialBackoff struct {
+	initial time.Duration
+	max     time.Duration
+}
+
+// NewExponentialBackoff doubles the delay on every attempt, starting at
+// initial (or the server's retry value, when it has sent one) and never
+// exceeding max. A max of zero means no cap.
+func NewExponentialBackoff(initial, max time.Duration) Backoff {
+	return &exponentialBackoff{initial: initial, max: max}
+}
+
+func (b *exponentialBackoff) Next(attempt int, retry time.Duration) time.Duration {
+	delay := b.initial
+	if retry > 0 {
+		delay = retry
+	}
+	for i := 1; i < attempt && delay > 0; i++ {
+		if b.max > 0 && delay >= b.max {
+			break
+		}
+		if delay > maxDuration/2 {
+			delay = maxDuration
+			break
+		}
+		delay *= 2
+	}
+	if b.max > 0 && delay > b.max {
+		delay = b.max
+	}
+	return delay
+}
+
+func (b *exponentialBackoff) Reset() {}
+
+type decorrelatedJitterBackoff struct {
+	base time.Duration
+	max  time.Duration
+	prev time.Duration
+}
+
+// NewDecorrelatedJitterBackoff picks each delay at random between base (or
+// the server's retry value, when it has sent one) and three times the
+// previous delay, capped at max. The randomness spreads out clients that
+// lost their connections at the same moment, such as after a server
+// restart, so they do not all reconnect in lockstep.
+func NewDecorrelatedJitterBackoff(base, max time.Duration) Backoff {
+	return &decorrelatedJitterBackoff{base: base, max: max}
+}
+
+func (b *decorrelatedJitterBackoff) Next(attempt int, retry time.Duration) time.Duration {
+	base := b.base
+	if retry > 0 {
+		base = retry
+	}
+	if base <= 0 {
+		return 0
+	}
+
+	upper := max(b.prev, base)
+	if upper > maxDuration/3 {
+		upper = maxDuration
+	} else {
+		upper *= 3
+	}
+	delay := base + rand.N(upper-base+1)
+	if b.max > 0 && delay > b.max {
+		delay = b.max
+	}
+	b.prev = delay
+	return delay
+}
+
+func (b *decorrelatedJitterBackoff) Reset() {
+	b.prev = 0
+}
+
+const maxDuration = time.Duration(1<<63 - 1)
@@ -0,0 +1,238 @@
+package sse
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConstantBackoff(t *testing.T) {
+	t.Parallel()
+
+	b := NewConstantBackoff(time.Second)
+	for attempt := 1; attempt <= 3; attempt++ {
+		if got := b.Next(attempt, 0); got != time.Second {
+			t.Fatalf("Next(%d, 0) = %v, want %v", attempt, got, time.Second)
+		}
+	}
+	if got := b.Next(1, 250*time.Millisecond); got != 250*time.Millisecond {
+		t.Fatalf("Next(1, 250ms) = %v, want server retry", got)
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	t.Parallel()
+
+	b := NewExponentialBackoff(100*time.Millisecond, time.Second)
+	want := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}
+	for i, w := range want {
+		if got := b.Next(i+1, 0); got != w {
+			t.Fatalf("Next(%d, 0) = %v, want %v", i+1, got, w)
+		}
+	}
+
+	if got := b.Next(2, 300*time.Millisecond); got != 600*time.Millisecond {
+		t.Fatalf("Next(2, 300ms) = %v, want %v", got, 600*time.Millisecond)
+	}
+
+	uncapped := NewExponentialBackoff(time.Second, 0)
+	if got := uncapped.Next(1000, 0); got != maxDuration {
+		t.Fatalf("Next(1000, 0) = %v, want saturation at %v", got, maxDuration)
+	}
+}
+
+func TestDecorrelatedJitterBackoff(t *testing.T) {
+	t.Parallel()
+
+	const base, limit = 10 * time.Millisecond, time.Second
+	b := NewDecorrelatedJitterBackoff(base, limit)
+
+	prev := base
+	for attempt := 1; attempt <= 50; attempt++ {
+		got := b.Next(attempt, 0)
+		if got < base || got > limit || got > 3*prev {
+			t.Fatalf("Next(%d, 0) = %v, want within [%v, min(%v, %v)]", attempt, got, base, 3*prev, limit)
+		}
+		prev = got
+	}
+
+	b.Reset()
+	if got := b.Next(1, 0); got > 3*base {
+		t.Fatalf("Next(1, 0) after Reset() = %v, want at most %v", got, 3*base)
+	}
+}
+
+type recordingBackoff struct {
+	attempts atomic.Int32
+	resets   atomic.Int32
+}
+
+func (b *recordingBackoff) Next(attempt int, retry time.Duration) time.Duration {
+	b.attempts.Store(int32(attempt))
+	return time.Millisecond
+}
+
+func (b *recordingBackoff) Reset() {
+	b.resets.Add(1)
+}
+
+func TestHttpReceiverUsesBackoff(t *testing.T) {
+	t.Parallel()
+
+	var connCount atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if connCount.Add(1) < 3 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "data: x\n\n")
+	}))
+	defer server.Close()
+
+	backoff := &recordingBackoff{}
+	receiver, err := CreateHttpReceiver(
+		server.URL,
+		WithHttpReceiverClient(server.Client()),
+		WithHttpReceiverBackoff(backoff),
+		WithHttpReceiverRetry(3, time.Hour),
+	)
+	if err != nil {
+		t.Fatalf("CreateHttpReceiver() error = %v", err)
+	}
+	defer receiver.Close()
+
+	if got := backoff.attempts.Load(); got != 2 {
+		t.Fatalf("last backoff attempt = %d, want 2", got)
+	}
+	if got := backoff.resets.Load(); got != 0 {
+		t.Fatalf("backoff resets = %d, want 0 before any connection ended", got)
+	}
+
+	// Reading past the end of the first stream reconnects, which resets the
+	// backoff since the connection was healthy.
+	for range 2 {
+		if _, err := receiver.Receive(); err != nil {
+			t.Fatalf("Receive() error = %v", err)
+		}
+	}
+	if got := backoff.resets.Load(); got != 1 {
+		t.Fatalf("backoff resets = %d, want 1", got)
+	}
+	if got := backoff.attempts.Load(); got != 1 {
+		t.Fatalf("backoff attempt before reconnecting = %d, want 1", got)
+	}
+}
+
+func TestHttpReceiverReportsDelayAfterDrop(t *testing.T) {
+	t.Parallel()
+
+	var connCount atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch connCount.Add(1) {
+		case 1:
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = io.WriteString(w, "data: x\n\n")
+		case 2:
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	var retries []int
+	backoff := &recordingBackoff{}
+	receiver, err := CreateHttpReceiver(
+		server.URL,
+		WithHttpReceiverClient(server.Client()),
+		WithHttpReceiverBackoff(backoff),
+		WithHttpReceiverRetry(3, time.Hour),
+		WithHttpReceiverOnRetry(func(attempt int, delay time.Duration, err error) {
+			retries = append(retries, attempt)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("CreateHttpReceiver() error = %v", err)
+	}
+	defer receiver.Close()
+
+	if _, err := receiver.Receive(); err != nil {
+		t.Fatalf("Receive() error = %v", err)
+	}
+	if _, err := receiver.Receive(); err == nil {
+		t.Fatal("Receive() error = nil, want the 204")
+	}
+
+	// The wait after the drop is the first delay of the outage, so the
+	// failed reconnect that follows backs off further.
+	if want := []int{0, 1}; !slices.Equal(retries, want) {
+		t.Fatalf("OnRetry attempts = %v, want %v", retries, want)
+	}
+	if got := backoff.attempts.Load(); got != 2 {
+		t.Fatalf("last backoff attempt = %d, want 2", got)
+	}
+}
+
+func TestHttpReceiverBacksOffAfterDrop(t *testing.T) {
+	t.Parallel()
+
+	const delay = 150 * time.Millisecond
+
+	var (
+		connCount atomic.Int32
+		droppedAt atomic.Int64
+	)
+	reconnected := make(chan time.Time, 1)
+
+	// A server that restarts: the stream is healthy, then drops.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if connCount.Add(1) > 1 {
+			reconnected <- time.Now()
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "data: x\n\n")
+		droppedAt.Store(time.Now().UnixNano())
+	}))
+	defer server.Close()
+
+	receiver, err := CreateHttpReceiver(
+		server.URL,
+		WithHttpReceiverClient(server.Client()),
+		WithHttpReceiverBackoff(NewDecorrelatedJitterBackoff(delay, time.Second)),
+	)
+	if err != nil {
+		t.Fatalf("CreateHttpReceiver() error = %v", err)
+	}
+	defer receiver.Close()
+
+	if _, err := receiver.Receive(); err != nil {
+		t.Fatalf("Receive() error = %v", err)
+	}
+	go func() {
+		_, _ = receiver.Receive()
+	}()
+
+	select {
+	case at := <-reconnected:
+		if gap := at.Sub(time.Unix(0, droppedAt.Load())); gap < delay {
+			t.Fatalf("reconnected %v after the stream dropped, want at least %v", gap, delay)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("receiver did not reconnect")
+	}
+}
@@ -0,0 +1,408 @@
+package sse
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// ErrBrokerClosed is returned by Broker methods called after Close.
+var ErrBrokerClosed = errors.New("sse: broker closed")
+
+// framePusher is implemented by pushers that can write a pre-encoded frame,
+// such as HttpPusher, letting a Broker encode each message only once.
+type framePusher interface {
+	PushFrame(f *Frame) error
+}
+
+// Broker fans messages published on a topic out to every Pusher subscribed
+// to it. It is safe for concurrent use. Pushers are used as map keys, so
+// they must be comparable; pointers such as *HttpPusher are.
+//
+// With WithBrokerStore, each topic keeps a history of published messages
+// and SubscribeFrom replays what a reconnecting client missed.
+type Broker struct {
+	mu         sync.RWMutex
+	topics     map[string]*brokerTopic
+	closed     bool
+	onEvict    func(topic string, p Pusher, err error)
+	newStore   func(topic string) (EventStore, error)
+	gapMessage func(topic, lastEventID string) *Message
+}
+
+// brokerTopic serializes publishing and subscribing on one topic, so a
+// message is either replayed to a new subscriber or pushed to it live,
+// never both or neither.
+type brokerTopic struct {
+	mu      sync.Mutex
+	subs    map[Pusher]struct{}
+	store   EventStore
+	removed bool // dropped from Broker.topics; look the topic up again
+
+	// Publish takes a ticket under mu and fans out only once served
+	// reaches it, so messages reach subscribers in the order they were
+	// stored without holding mu during delivery. next is guarded by mu,
+	// served by deliverMu.
+	next      uint64
+	served    uint64
+	deliverMu sync.Mutex
+	turn      *sync.Cond
+}
+
+type BrokerOption func(*Broker)
+
+// WithBrokerOnEvict sets a callback invoked for each topic a pusher is
+// removed from because pushing to it failed, after the pusher is closed.
+func WithBrokerOnEvict(onEvict func(topic string, p Pusher, err error)) BrokerOption {
+	return func(b *Broker) {
+		b.onEvict = onEvict
+	}
+}
+
+// WithBrokerStore keeps the history of each topic in the EventStore that
+// newStore returns for it, called once per topic. Published messages are
+// appended to it, which assigns ids to messages without one. An error from
+// newStore is returned by the Subscribe or Publish call that needed the
+// store, and the next call tries again. Close closes each store that is an
+// io.Closer.
+func WithBrokerStore(newStore func(topic string) (EventStore, error)) BrokerOption {
+	return func(b *Broker) {
+		b.newStore = newStore
+	}
+}
+
+// WithBrokerGapMessage sets the message SubscribeFrom pushes when the
+// client's last event is no longer in the store, before replaying what the
+// store still has. Returning nil pushes nothing. By default the message is
+// a "gap" event whose data is the last event id the client had.
+func WithBrokerGapMessage(gapMessage func(topic, lastEventID string) *Message) BrokerOption {
+	return func(b *Broker) {
+		b.gapMessage = gapMessage
+	}
+}
+
+func defaultGapMessage(topic, lastEventID string) *Message {
+	return &Message{Event: "gap", Data: lastEventID}
+}
+
+func NewBroker(opts ...BrokerOption) *Broker {
+	b := &Broker{
+		topics:     make(map[string]*brokerTopic),
+		gapMessage: defaultGapMessage,
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	return b
+}
+
+// topic returns the named topic, locked, creating it if create is set. It
+// returns nil if the topic does not exist and create is not set, or if the
+// broker is closed.
+func (b *Broker) topic(name string, create bool) (*brokerTopic, error) {
+	for {
+		b.mu.RLock()
+		t, closed := b.topics[name], b.closed
+		b.mu.RUnlock()
+
+		if closed {
+			return nil, ErrBrokerClosed
+		}
+		if t == nil {
+			if !create {
+				return nil, nil
+			}
+			b.mu.Lock()
+			if b.closed {
+				b.mu.Unlock()
+				return nil, ErrBrokerClosed
+			}
+			if t = b.topics[name]; t == nil {
+				t = &brokerTopic{subs: make(map[Pusher]struct{})}
+				t.turn = sync.NewCond(&t.deliverMu)
+				if b.newStore != nil {
+					store, err := b.newStore(name)
+					if err != nil {
+						b.mu.Unlock()
+						return nil, err
+					}
+					t.store = store
+				}
+				b.topics[name] = t
+			}
+			b.mu.Unlock()
+		}
+
+		t.mu.Lock()
+		if !t.removed {
+			return t, nil
+		}
+		t.mu.Unlock()
+	}
+}
+
+// Subscribe adds p to topic. A pusher may subscribe to several topics.
+func (b *Broker) Subscribe(topic string, p Pusher) error {
+	t, err := b.topic(topic, true)
+	if err != nil {
+		return err
+	}
+	defer t.mu.Unlock()
+
+	t.subs[p] = struct{}{}
+	return nil
+}
+
+// SubscribeFrom adds p to topic after pushing every stored message
+// published after lastEventID, typically the Last-Event-ID header of a
+// reconnecting client. An empty lastEventID replays nothing. If lastEventID
+// is no longer stored, the gap message is pushed and then everything still
+// stored.
+//
+// The replay runs without holding up publishing; only the messages
+// published meanwhile are pushed while Publish waits, just before p is
+// subscribed. If pushing fails, p is not subscribed and the error is
+// returned. Without WithBrokerStore, SubscribeFrom is the same as Subscribe.
+func (b *Broker) SubscribeFrom(topic string, p Pusher, lastEventID string) error {
+	t, err := b.topic(topic, true)
+	if err != nil {
+		return err
+	}
+	store := t.store
+	t.mu.Unlock()
+
+	if store == nil || lastEventID == "" {
+		return b.Subscribe(topic, p)
+	}
+
+	last, err := b.replay(topic, store, p, lastEventID)
+	if err != nil {
+		return err
+	}
+
+	t, err = b.topic(topic, true)
+	if err != nil {
+		return err
+	}
+	defer t.mu.Unlock()
+
+	if _, err := b.replay(topic, t.store, p, last); err != nil {
+		return err
+	}
+	t.subs[p] = struct{}{}
+	return nil
+}
+
+// replay pushes to p the messages stored after lastID, or the gap message
+// and everything stored if lastID is gone; an empty lastID replays
+// everything. It returns the id to replay after next time.
+func (b *Broker) replay(topic string, store EventStore, p Pusher, lastID string) (string, error) {
+	last := lastID
+	push := func(msg *Message) error {
+		if err := p.Push(msg); err != nil {
+			return err
+		}
+		last = msg.Id
+		return nil
+	}
+
+	err := store.ReplayAfter(lastID, push)
+	if errors.Is(err, ErrReplayGap) {
+		err = nil
+		if msg := b.gapMessage(topic, lastID); msg != nil {
+			err = p.Push(msg)
+		}
+		if err == nil {
+			last = ""
+			err = store.ReplayAfter("", push)
+		}
+	}
+	return last, err
+}
+
+// Unsubscribe removes p from topic without closing it.
+func (b *Broker) Unsubscribe(topic string, p Pusher) {
+	t, _ := b.topic(topic, false)
+	if t == nil {
+		return
+	}
+	delete(t.subs, p)
+	empty := len(t.subs) == 0 && t.store == nil
+	t.mu.Unlock()
+
+	if empty {
+		b.dropIfEmpty(topic, t)
+	}
+}
+
+// dropIfEmpty forgets t if it has no subscribers and no history to keep.
+func (b *Broker) dropIfEmpty(name string, t *brokerTopic) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if b.topics[name] == t && len(t.subs) == 0 && t.store == nil {
+		t.removed = true
+		delete(b.topics, name)
+	}
+}
+
+// Subscribers returns the number of pushers subscribed to topic.
+func (b *Broker) Subscribers(topic string) int {
+	t, _ := b.topic(topic, false)
+	if t == nil {
+		return 0
+	}
+	defer t.mu.Unlock()
+
+	return len(t.subs)
+}
+
+// Publish sends msg to every pusher subscribed to topic. The message is
+// encoded once and the same frame written to each pusher that supports it.
+// With WithBrokerStore, msg is first appended to the topic's history, which
+// sets its Id if it has none.
+//
+// Messages published on a topic reach each subscriber in the order they
+// were stored, even when published concurrently; a slow subscriber
+// therefore holds up the topic, unless it queues, as an HttpPusher with
+// WithHttpPusherQueue does.
+//
+// A pusher whose push fails is closed and removed from every topic; that is
+// not an error for Publish, which only fails when msg cannot be encoded or
+// stored, the topic's store cannot be created, or the broker is closed.
+func (b *Broker) Publish(topic string, msg *Message) error {
+	t, err := b.topic(topic, b.newStore != nil)
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		// Nobody is subscribed and there is no history to keep, but the
+		// message must still be valid.
+		return validateMessage(msg)
+	}
+
+	if t.store != nil {
+		if err := t.store.Append(msg); err != nil {
+			t.mu.Unlock()
+			return err
+		}
+	}
+	frame, err := NewFrame(msg)
+	if err != nil {
+		t.mu.Unlock()
+		return err
+	}
+	subs := make([]Pusher, 0, len(t.subs))
+	for p := range t.subs {
+		subs = append(subs, p)
+	}
+	ticket := t.next
+	t.next++
+	t.mu.Unlock()
+
+	t.deliverMu.Lock()
+	for t.served != ticket {
+		t.turn.Wait()
+	}
+	t.deliverMu.Unlock()
+
+	var failed []Pusher
+	var errs []error
+	for _, p := range subs {
+		var err error
+		if fp, ok := p.(framePusher); ok {
+			err = fp.PushFrame(frame)
+		} else {
+			err = p.Push(msg)
+		}
+		if err != nil {
+			failed = append(failed, p)
+			errs = append(errs, err)
+		}
+	}
+	t.deliverMu.Lock()
+	t.served++
+	t.turn.Broadcast()
+	t.deliverMu.Unlock()
+
+	// Evict only once delivery is done, so the next Publish on this topic
+	// is not held up by it.
+	for i, p := range failed {
+		b.evict(p, errs[i])
+	}
+	return nil
+}
+
+// evict closes p and removes it from every topic.
+func (b *Broker) evict(p Pusher, err error) {
+	_ = p.Close()
+
+	b.mu.RLock()
+	topics := make(map[string]*brokerTopic, len(b.topics))
+	for name, t := range b.topics {
+		topics[name] = t
+	}
+	b.mu.RUnlock()
+
+	for name, t := range topics {
+		t.mu.Lock()
+		_, ok := t.subs[p]
+		delete(t.subs, p)
+		empty := len(t.subs) == 0 && t.store == nil
+		t.mu.Unlock()
+
+		if !ok {
+			continue
+		}
+		if empty {
+			b.dropIfEmpty(name, t)
+		}
+		if b.onEvict != nil {
+			b.onEvict(name, p, err)
+		}
+	}
+}
+
+// Close closes every subscribed pusher and every topic store that is an
+// io.Closer, and makes further calls to Subscribe and Publish fail with
+// ErrBrokerClosed. It returns the errors from closing the stores.
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrBrokerClosed
+	}
+	b.closed = true
+	topics := b.topics
+	b.topics = make(map[string]*brokerTopic)
+	b.mu.Unlock()
+
+	closed := make(map[Pusher]struct{})
+	var errs []error
+	for _, t := range topics {
+		t.mu.Lock()
+		t.removed = true
+		subs := t.subs
+		t.subs = make(map[Pusher]struct{})
+		t.mu.Unlock()
+
+		for p := range subs {
+			if _, ok := closed[p]; ok {
+				continue
+			}
+			closed[p] = struct{}{}
+			_ = p.Close()
+		}
+		if c, ok := t.store.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
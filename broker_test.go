@@ -0,0 +1,446 @@
+package sse
+
+import (
+	"errors"
+	"runtime"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakePusher records pushed messages and fails every push once failing is
+// set.
+type fakePusher struct {
+	mu      sync.Mutex
+	data    []string
+	failing bool
+	closed  bool
+}
+
+func (p *fakePusher) Push(msg *Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failing || p.closed {
+		return errors.New("broken pipe")
+	}
+	p.data = append(p.data, msg.Data)
+	return nil
+}
+
+func (p *fakePusher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
+
+func (p *fakePusher) snapshot() ([]string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.data), p.closed
+}
+
+func TestBroker(t *testing.T) {
+	t.Parallel()
+
+	var evicted []string
+	broker := NewBroker(WithBrokerOnEvict(func(topic string, p Pusher, err error) {
+		evicted = append(evicted, topic)
+	}))
+
+	a, b, c := &fakePusher{}, &fakePusher{}, &fakePusher{}
+	for _, sub := range []struct {
+		topic string
+		p     Pusher
+	}{{"news", a}, {"news", b}, {"sports", b}, {"sports", c}} {
+		if err := broker.Subscribe(sub.topic, sub.p); err != nil {
+			t.Fatalf("Subscribe() error = %v", err)
+		}
+	}
+
+	if err := broker.Publish("news", &Message{Data: "1"}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	broker.Unsubscribe("sports", c)
+	b.mu.Lock()
+	b.failing = true
+	b.mu.Unlock()
+	if err := broker.Publish("news", &Message{Data: "2"}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if err := broker.Publish("sports", &Message{Data: "3"}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	if got, _ := a.snapshot(); !slices.Equal(got, []string{"1", "2"}) {
+		t.Fatalf("a received %q, want [1 2]", got)
+	}
+	if got, closed := b.snapshot(); !slices.Equal(got, []string{"1"}) || !closed {
+		t.Fatalf("b received %q (closed %v), want [1] and closed", got, closed)
+	}
+	if got, closed := c.snapshot(); len(got) != 0 || closed {
+		t.Fatalf("c received %q (closed %v), want nothing after Unsubscribe", got, closed)
+	}
+	slices.Sort(evicted)
+	if !slices.Equal(evicted, []string{"news", "sports"}) {
+		t.Fatalf("evicted from %q, want [news sports]", evicted)
+	}
+	if n := broker.Subscribers("sports"); n != 0 {
+		t.Fatalf("Subscribers(sports) = %d, want 0", n)
+	}
+
+	if err := broker.Publish("news", &Message{Event: "bad\nevent"}); !errors.Is(err, ErrInvalidField) {
+		t.Fatalf("Publish() error = %v, want %v", err, ErrInvalidField)
+	}
+
+	if err := broker.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, closed := a.snapshot(); !closed {
+		t.Fatal("Close() did not close subscribed pusher")
+	}
+	if err := broker.Publish("news", &Message{Data: "4"}); !errors.Is(err, ErrBrokerClosed) {
+		t.Fatalf("Publish() after Close error = %v, want %v", err, ErrBrokerClosed)
+	}
+	if err := broker.Subscribe("news", a); !errors.Is(err, ErrBrokerClosed) {
+		t.Fatalf("Subscribe() after Close error = %v, want %v", err, ErrBrokerClosed)
+	}
+}
+
+func TestBrokerPushesFrames(t *testing.T) {
+	t.Parallel()
+
+	broker := NewBroker()
+	defer broker.Close()
+
+	writers := []*recordingResponseWriter{{}, {}}
+	for _, w := range writers {
+		pusher, err := CreateHttpPusher(w)
+		if err != nil {
+			t.Fatalf("CreateHttpPusher() error = %v", err)
+		}
+		if err := broker.Subscribe("t", pusher); err != nil {
+			t.Fatalf("Subscribe() error = %v", err)
+		}
+	}
+
+	if err := broker.Publish("t", &Message{Id: "1", Event: "e", Data: "x"}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	for i, w := range writers {
+		if out, _ := w.snapshot(); out != "id: 1\nevent: e\ndata: x\n\n" {
+			t.Fatalf("pusher %d wrote %q", i, out)
+		}
+	}
+}
+
+func TestBrokerConcurrentUse(t *testing.T) {
+	t.Parallel()
+
+	broker := NewBroker()
+	defer broker.Close()
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p := &fakePusher{}
+			topic := strconv.Itoa(i % 2)
+			for j := range 100 {
+				if err := broker.Subscribe(topic, p); err != nil {
+					t.Errorf("Subscribe() error = %v", err)
+					return
+				}
+				_ = broker.Publish(topic, &Message{Data: strconv.Itoa(j)})
+				broker.Unsubscribe(topic, p)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestBrokerSubscribeFrom(t *testing.T) {
+	t.Parallel()
+
+	broker := NewBroker(WithBrokerStore(func(topic string) (EventStore, error) {
+		return NewMemoryStore(3, 0), nil
+	}))
+	defer broker.Close()
+
+	// History is kept even before anyone subscribes.
+	for i := range 5 {
+		msg := &Message{Data: strconv.Itoa(i + 1)}
+		if err := broker.Publish("t", msg); err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+		if msg.Id != strconv.Itoa(i+1) {
+			t.Fatalf("Publish() assigned Id %q, want %q", msg.Id, strconv.Itoa(i+1))
+		}
+	}
+
+	tests := []struct {
+		name        string
+		lastEventID string
+		want        []string
+	}{
+		{name: "new client", lastEventID: "", want: []string{"6"}},
+		{name: "resuming", lastEventID: "4", want: []string{"5", "6"}},
+		// A gap event carrying the client's last id, then all that is left.
+		{name: "evicted", lastEventID: "1", want: []string{"1", "3", "4", "5", "6"}},
+	}
+
+	pushers := make([]*fakePusher, len(tests))
+	for i, tt := range tests {
+		pushers[i] = &fakePusher{}
+		if err := broker.SubscribeFrom("t", pushers[i], tt.lastEventID); err != nil {
+			t.Fatalf("%s: SubscribeFrom() error = %v", tt.name, err)
+		}
+	}
+	if err := broker.Publish("t", &Message{Data: "6"}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	for i, tt := range tests {
+		if got, _ := pushers[i].snapshot(); !slices.Equal(got, tt.want) {
+			t.Fatalf("%s: received %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// idPusher records the ids of pushed messages.
+type idPusher struct {
+	mu  sync.Mutex
+	ids []int
+}
+
+func (p *idPusher) Push(msg *Message) error {
+	id, err := strconv.Atoi(msg.Id)
+	if err != nil {
+		return err
+	}
+	runtime.Gosched() // let concurrent publishes overtake each other
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ids = append(p.ids, id)
+	return nil
+}
+
+func (p *idPusher) Close() error {
+	return nil
+}
+
+func TestBrokerConcurrentPublishOrder(t *testing.T) {
+	t.Parallel()
+
+	broker := NewBroker(WithBrokerStore(func(topic string) (EventStore, error) {
+		return NewMemoryStore(1000, 0), nil
+	}))
+	defer broker.Close()
+
+	pushers := []*idPusher{{}, {}, {}}
+	for _, p := range pushers {
+		if err := broker.Subscribe("t", p); err != nil {
+			t.Fatalf("Subscribe() error = %v", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				if err := broker.Publish("t", &Message{Data: "x"}); err != nil {
+					t.Errorf("Publish() error = %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Ids are assigned in store order, so a client resuming from the last
+	// id it saw has seen everything before it.
+	for i, p := range pushers {
+		if len(p.ids) != 800 || !slices.IsSorted(p.ids) {
+			t.Fatalf("pusher %d received %d ids, sorted = %v, want 800 in order", i, len(p.ids), slices.IsSorted(p.ids))
+		}
+	}
+}
+
+// gatedPusher records pushed data, holding each push until the gate opens.
+type gatedPusher struct {
+	fakePusher
+	gate    chan struct{}
+	pushing chan struct{} // receives each time a push starts
+}
+
+func (p *gatedPusher) Push(msg *Message) error {
+	p.pushing <- struct{}{}
+	<-p.gate
+	return p.fakePusher.Push(msg)
+}
+
+func TestBrokerSubscribeFromDoesNotBlockPublish(t *testing.T) {
+	t.Parallel()
+
+	broker := NewBroker(WithBrokerStore(func(topic string) (EventStore, error) {
+		return NewMemoryStore(10, 0), nil
+	}))
+	defer broker.Close()
+
+	for i := range 3 {
+		if err := broker.Publish("t", &Message{Data: strconv.Itoa(i + 1)}); err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+	}
+
+	p := &gatedPusher{gate: make(chan struct{}), pushing: make(chan struct{}, 16)}
+	subscribed := make(chan error, 1)
+	go func() {
+		subscribed <- broker.SubscribeFrom("t", p, "1")
+	}()
+
+	// The replay is stuck on the slow client; publishing goes on.
+	<-p.pushing
+	published := make(chan error, 1)
+	go func() {
+		published <- broker.Publish("t", &Message{Data: "4"})
+	}()
+	select {
+	case err := <-published:
+		if err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish() waited for a replay in progress")
+	}
+
+	close(p.gate)
+	if err := <-subscribed; err != nil {
+		t.Fatalf("SubscribeFrom() error = %v", err)
+	}
+	if err := broker.Publish("t", &Message{Data: "5"}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	want := []string{"2", "3", "4", "5"}
+	if got, _ := p.snapshot(); !slices.Equal(got, want) {
+		t.Fatalf("received %q, want %q", got, want)
+	}
+}
+
+func TestBrokerPublishDoesNotBlockSubscribers(t *testing.T) {
+	t.Parallel()
+
+	broker := NewBroker()
+	defer broker.Close()
+
+	slow := &gatedPusher{gate: make(chan struct{}), pushing: make(chan struct{}, 16)}
+	if err := broker.Subscribe("t", slow); err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	published := make(chan error, 2)
+	go func() {
+		published <- broker.Publish("t", &Message{Data: "1"})
+	}()
+	<-slow.pushing
+	// The second Publish waits its turn behind the first.
+	go func() {
+		published <- broker.Publish("t", &Message{Data: "2"})
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// While a fan-out is stuck on a slow client, the topic stays usable.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = broker.Subscribe("t", &fakePusher{})
+		_ = broker.Subscribe("u", &fakePusher{})
+		_ = broker.Subscribers("t")
+		broker.Unsubscribe("u", &fakePusher{})
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		close(slow.gate)
+		t.Fatal("Subscribe() waited for a Publish in progress")
+	}
+
+	close(slow.gate)
+	for range 2 {
+		if err := <-published; err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+	}
+
+	want := []string{"1", "2"}
+	if got, _ := slow.snapshot(); !slices.Equal(got, want) {
+		t.Fatalf("received %q, want %q", got, want)
+	}
+}
+
+// closingStore records whether Close was called.
+type closingStore struct {
+	EventStore
+	closed atomic.Bool
+}
+
+func (s *closingStore) Close() error {
+	s.closed.Store(true)
+	return nil
+}
+
+func TestBrokerStoreFactory(t *testing.T) {
+	t.Parallel()
+
+	errOpen := errors.New("open failed")
+	var fail atomic.Bool
+	fail.Store(true)
+	var stores []*closingStore
+	broker := NewBroker(WithBrokerStore(func(topic string) (EventStore, error) {
+		if fail.Load() {
+			return nil, errOpen
+		}
+		s := &closingStore{EventStore: NewMemoryStore(10, 0)}
+		stores = append(stores, s)
+		return s, nil
+	}))
+
+	if err := broker.Subscribe("t", &fakePusher{}); !errors.Is(err, errOpen) {
+		t.Fatalf("Subscribe() error = %v, want %v", err, errOpen)
+	}
+	if err := broker.SubscribeFrom("t", &fakePusher{}, "1"); !errors.Is(err, errOpen) {
+		t.Fatalf("SubscribeFrom() error = %v, want %v", err, errOpen)
+	}
+	if err := broker.Publish("t", &Message{Data: "a"}); !errors.Is(err, errOpen) {
+		t.Fatalf("Publish() error = %v, want %v", err, errOpen)
+	}
+
+	// A failed factory call leaves no topic behind, so the next call retries.
+	fail.Store(false)
+	if err := broker.Publish("t", &Message{Data: "a"}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if err := broker.Publish("u", &Message{Data: "b"}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if len(stores) != 2 {
+		t.Fatalf("stores created = %d, want 2", len(stores))
+	}
+
+	if err := broker.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	for i, s := range stores {
+		if !s.closed.Load() {
+			t.Fatalf("store %d not closed by Broker.Close()", i)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package sse
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReadyState is the connection state of an EventSource, as in the browser
+// API.
+type ReadyState int32
+
+const (
+	ReadyStateConnecting ReadyState = iota
+	ReadyStateOpen
+	ReadyStateClosed
+)
+
+func (s ReadyState) String() string {
+	switch s {
+	case ReadyStateConnecting:
+		return "CONNECTING"
+	case ReadyStateOpen:
+		return "OPEN"
+	case ReadyStateClosed:
+		return "CLOSED"
+	default:
+		return "ReadyState(" + strconv.Itoa(int(s)) + ")"
+	}
+}
+
+// EventSource is a client modelled on the browser's EventSource. Events are
+// dispatched to listeners registered by name; events sent without an event
+// field are named "message". All handlers run one at a time on a goroutine
+// started by Start, so they need no locking among themselves but should not
+// block for long.
+//
+// As in browsers, events without data are not dispatched, and comments never
+// are.
+type EventSource struct {
+	receiver *HttpReceiver
+	state    atomic.Int32
+	started  atomic.Bool
+	done     chan struct{}
+
+	mu        sync.RWMutex
+	listeners map[string][]*listener
+	onOpen    func()
+	onMessage func(msg *Message)
+	onError   func(err error)
+}
+
+type listener struct {
+	handler func(msg *Message)
+}
+
+// NewEventSource creates an EventSource for url. It does not connect until
+// Start is called, so listeners can be registered first. opts configure the
+// underlying HttpReceiver; its lifecycle hooks still run.
+func NewEventSource(url string, opts ...HttpReceiverOption) (*EventSource, error) {
+	receiver, err := newHttpReceiver(url, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	es := &EventSource{
+		receiver:  receiver,
+		done:      make(chan struct{}),
+		listeners: make(map[string][]*listener),
+	}
+	es.hook(receiver)
+	return es, nil
+}
+
+// hook chains the EventSource's state tracking onto the receiver's lifecycle
+// hooks, keeping any set through options.
+func (es *EventSource) hook(r *HttpReceiver) {
+	onConnecting := r.onConnecting
+	r.onConnecting = func(attempt int) {
+		es.setState(ReadyStateConnecting)
+		if onConnecting != nil {
+			onConnecting(attempt)
+		}
+	}
+
+	onOpen := r.onOpen
+	r.onOpen = func(resp *http.Response) {
+		if onOpen != nil {
+			onOpen(resp)
+		}
+		es.setState(ReadyStateOpen)
+		es.mu.RLock()
+		handler := es.onOpen
+		es.mu.RUnlock()
+		if handler != nil {
+			handler()
+		}
+	}
+
+	onRetry := r.onRetry
+	r.onRetry = func(attempt int, delay time.Duration, err error) {
+		if onRetry != nil {
+			onRetry(attempt, delay, err)
+		}
+		// With no failed attempts, err is a dropped stream, which
+		// onDisconnect has already reported.
+		if attempt > 0 {
+			es.dispatchError(err)
+		}
+	}
+
+	onDisconnect := r.onDisconnect
+	r.onDisconnect = func(cause error) {
+		if onDisconnect != nil {
+			onDisconnect(cause)
+		}
+		es.setState(ReadyStateConnecting)
+		es.dispatchError(cause)
+	}
+
+	onClosed := r.onClosed
+	r.onClosed = func(err error) {
+		es.state.Store(int32(ReadyStateClosed))
+		if onClosed != nil {
+			onClosed(err)
+		}
+	}
+}
+
+// setState moves to state unless the EventSource has been closed.
+func (es *EventSource) setState(state ReadyState) {
+	for {
+		cur := es.state.Load()
+		if ReadyState(cur) == ReadyStateClosed || es.state.CompareAndSwap(cur, int32(state)) {
+			return
+		}
+	}
+}
+
+// ReadyState returns the current connection state.
+func (es *EventSource) ReadyState() ReadyState {
+	return ReadyState(es.state.Load())
+}
+
+// AddEventListener registers handler for events with the given name and
+// returns a function that removes it. Use "message" for events sent without
+// an event field. It is safe to call at any time, including from a handler.
+func (es *EventSource) AddEventListener(event string, handler func(msg *Message)) (remove func()) {
+	l := &listener{handler: handler}
+
+	es.mu.Lock()
+	// Listener slices are never modified in place, so dispatch can use one
+	// without holding the lock.
+	es.listeners[event] = append(slices.Clip(es.listeners[event]), l)
+	es.mu.Unlock()
+
+	return func() {
+		es.mu.Lock()
+		defer es.mu.Unlock()
+		ls := es.listeners[event]
+		if i := slices.Index(ls, l); i >= 0 {
+			es.listeners[event] = slices.Delete(slices.Clone(ls), i, i+1)
+		}
+	}
+}
+
+// OnOpen sets the handler called each time a connection opens, replacing
+// any previous one.
+func (es *EventSource) OnOpen(handler func()) {
+	es.mu.Lock()
+	es.onOpen = handler
+	es.mu.Unlock()
+}
+
+// OnMessage sets the handler for events named "message", which includes
+// those sent without an event field, replacing any previous one. It runs
+// after listeners added for "message".
+func (es *EventSource) OnMessage(handler func(msg *Message)) {
+	es.mu.Lock()
+	es.onMessage = handler
+	es.mu.Unlock()
+}
+
+// OnError sets the handler for errors, replacing any previous one. It is
+// called when a connection drops or an attempt to reconnect fails, while
+// ReadyState is CONNECTING, and with the final error once the EventSource
+// gives up and becomes CLOSED.
+func (es *EventSource) OnError(handler func(err error)) {
+	es.mu.Lock()
+	es.onError = handler
+	es.mu.Unlock()
+}
+
+// Start connects and dispatches events on a new goroutine until Close is
+// called or the stream fails for good. Calling it more than once has no
+// effect.
+func (es *EventSource) Start() {
+	if es.started.Swap(true) {
+		return
+	}
+	go es.run()
+}
+
+// Done returns a channel that is closed once the EventSource has stopped
+// and no more handlers will be called.
+func (es *EventSource) Done() <-chan struct{} {
+	return es.done
+}
+
+// Close stops the EventSource. A handler that is running completes, but no
+// further events are dispatched. It is safe to call from a handler.
+func (es *EventSource) Close() error {
+	es.state.Store(int32(ReadyStateClosed))
+	err := es.receiver.Close()
+	if !es.started.Swap(true) {
+		close(es.done)
+	}
+	return err
+}
+
+func (es *EventSource) run() {
+	defer close(es.done)
+
+	for {
+		msg, err := es.receiver.Receive()
+		if err != nil {
+			if errors.Is(err, http.ErrServerClosed) {
+				return
+			}
+			if errors.Is(err, ErrMessageTooLarge) {
+				es.dispatchError(err)
+				continue
+			}
+			es.state.Store(int32(ReadyStateClosed))
+			es.dispatchError(err)
+			_ = es.receiver.Close()
+			return
+		}
+
+		if msg.Data == "" || es.ReadyState() == ReadyStateClosed {
+			continue
+		}
+		es.dispatch(msg)
+	}
+}
+
+func (es *EventSource) dispatch(msg *Message) {
+	event := msg.Event
+	if event == "" {
+		event = "message"
+	}
+
+	es.mu.RLock()
+	listeners := es.listeners[event]
+	var onMessage func(msg *Message)
+	if event == "message" {
+		onMessage = es.onMessage
+	}
+	es.mu.RUnlock()
+
+	for _, l := range listeners {
+		l.handler(msg)
+	}
+	if onMessage != nil {
+		onMessage(msg)
+	}
+}
+
+func (es *EventSource) dispatchError(err error) {
+	es.mu.RLock()
+	handler := es.onError
+	es.mu.RUnlock()
+
+	if handler != nil {
+		handler(err)
+	}
+}
@@ -0,0 +1,131 @@
+package sse
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEventSource(t *testing.T) {
+	t.Parallel()
+
+	var connCount atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if connCount.Add(1) > 1 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "data: hello\n\nevent: tick\ndata: 1\n\n: ping\n\nid: 3\n\nevent: other\ndata: x\n\n")
+	}))
+	defer server.Close()
+
+	es, err := NewEventSource(
+		server.URL,
+		WithHttpReceiverClient(server.Client()),
+		WithHttpReceiverRetry(1, 0),
+	)
+	if err != nil {
+		t.Fatalf("NewEventSource() error = %v", err)
+	}
+	defer es.Close()
+
+	if got := es.ReadyState(); got != ReadyStateConnecting {
+		t.Fatalf("ReadyState() = %v, want %v", got, ReadyStateConnecting)
+	}
+
+	var (
+		mu     sync.Mutex
+		events []string
+		errs   []error
+	)
+	record := func(s string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, s)
+	}
+
+	es.OnOpen(func() {
+		record("open " + es.ReadyState().String())
+	})
+	es.OnMessage(func(msg *Message) {
+		record("message " + msg.Data)
+	})
+	es.AddEventListener("tick", func(msg *Message) {
+		record("tick " + msg.Data)
+	})
+	remove := es.AddEventListener("other", func(msg *Message) {
+		record("other " + msg.Data)
+	})
+	remove()
+	es.OnError(func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs = append(errs, err)
+	})
+
+	es.Start()
+	select {
+	case <-es.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("EventSource did not stop after 204 No Content")
+	}
+
+	if got := es.ReadyState(); got != ReadyStateClosed {
+		t.Fatalf("ReadyState() = %v, want %v", got, ReadyStateClosed)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"open OPEN", "message hello", "tick 1"}
+	if !slices.Equal(events, want) {
+		t.Fatalf("events = %q, want %q", events, want)
+	}
+	var statusErr *StatusError
+	if len(errs) != 2 || errs[0] != io.EOF || !errors.As(errs[1], &statusErr) || statusErr.StatusCode != http.StatusNoContent {
+		t.Fatalf("errors = %v, want [EOF, 204 StatusError]", errs)
+	}
+}
+
+func TestEventSourceClose(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		<-req.Context().Done()
+	}))
+	defer server.Close()
+
+	es, err := NewEventSource(server.URL, WithHttpReceiverClient(server.Client()))
+	if err != nil {
+		t.Fatalf("NewEventSource() error = %v", err)
+	}
+
+	var received atomic.Int32
+	es.OnMessage(func(msg *Message) {
+		received.Add(1)
+		// Closing from a handler must not deadlock.
+		_ = es.Close()
+	})
+	es.Start()
+
+	select {
+	case <-es.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("EventSource did not stop after Close()")
+	}
+	if got := received.Load(); got != 1 {
+		t.Fatalf("messages = %d, want 1", got)
+	}
+	if got := es.ReadyState(); got != ReadyStateClosed {
+		t.Fatalf("ReadyState() = %v, want %v", got, ReadyStateClosed)
+	}
+}
@@ -0,0 +1,597 @@
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// fileRecordHeader is the size of the header in front of every record
+	// in a FileStore segment: payload length and CRC-32C (4 bytes each),
+	// then sequence number and Unix time in nanoseconds (8 bytes each), all
+	// little-endian. The checksum covers everything after itself. The
+	// payload is the message in wire format.
+	fileRecordHeader = 24
+
+	// maxFileRecord bounds the payload length of a record. Append rejects
+	// larger messages, and reading a segment back treats a larger length as
+	// corrupt, so it cannot trigger a huge allocation.
+	maxFileRecord = 64 << 20
+
+	defaultSegmentSize = 16 << 20
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// errCorruptRecord marks a record that fails its checks. When opening a
+// store, one in the last segment is taken to be a torn write and cut off;
+// one in an earlier segment fails the open.
+var errCorruptRecord = errors.New("sse: corrupt record")
+
+// SyncPolicy decides when a FileStore flushes appended messages to stable
+// storage.
+type SyncPolicy int
+
+const (
+	// SyncAlways syncs before Append returns, so an appended message
+	// survives a crash of the machine. This is the default.
+	SyncAlways SyncPolicy = iota
+	// SyncInterval syncs in the background at a fixed interval, losing at
+	// most that much history if the machine crashes.
+	SyncInterval
+	// SyncNever leaves flushing to the operating system. Messages survive
+	// the process crashing, but not the machine.
+	SyncNever
+)
+
+// FileStore is an EventStore kept in append-only segment files in a
+// directory, so history survives restarts. Each file holds a run of
+// messages; when the one being written reaches the segment size a new one is
+// started, and whole segments are deleted, oldest first, to honor the
+// retention limits. An index from event id to file position is rebuilt in
+// memory when the store is opened.
+//
+// A directory must only be used by one FileStore at a time.
+type FileStore struct {
+	dir          string
+	segmentSize  int64
+	maxBytes     int64
+	maxAge       time.Duration
+	syncPolicy   SyncPolicy
+	syncInterval time.Duration
+	now          func() time.Time
+
+	mu       sync.RWMutex
+	segments []*fileSegment // oldest first; the last one is written to
+	index    map[string]fileLocation
+	seq      uint64 // sequence number of the last record
+	size     int64  // total size of all segments
+	buf      bytes.Buffer
+	dirty    bool // written since the last sync
+	closed   bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+var _ EventStore = (*FileStore)(nil)
+
+type fileSegment struct {
+	path   string
+	f      *os.File
+	size   int64
+	lastAt time.Time // time of the newest record
+	ids    []string  // ids of the records, for pruning the index
+
+	// readers counts replays reading the segment. One dropped by retention
+	// while it is read is only retired, and deleted once the last reader
+	// is done.
+	readers atomic.Int32
+	retired bool
+}
+
+// delete closes and removes the segment file.
+func (seg *fileSegment) delete() error {
+	_ = seg.f.Close()
+	return os.Remove(seg.path)
+}
+
+// fileSpan is the part of a segment a replay reads.
+type fileSpan struct {
+	seg        *fileSegment
+	start, end int64
+}
+
+// fileLocation is where the record after the one with a given id starts.
+type fileLocation struct {
+	seg  *fileSegment
+	next int64
+}
+
+type FileStoreOption func(*FileStore)
+
+// WithFileStoreSync sets when appended messages are synced to disk. The
+// interval is only used by SyncInterval.
+func WithFileStoreSync(policy SyncPolicy, interval time.Duration) FileStoreOption {
+	return func(s *FileStore) {
+		s.syncPolicy = policy
+		s.syncInterval = interval
+	}
+}
+
+// WithFileStoreSegmentSize sets the size at which a new segment file is
+// started, 16 MiB by default. Retention works on whole segments, so smaller
+// segments track the limits more closely at the cost of more files.
+func WithFileStoreSegmentSize(size int64) FileStoreOption {
+	return func(s *FileStore) {
+		s.segmentSize = size
+	}
+}
+
+// WithFileStoreRetention deletes the oldest segments while the store is
+// larger than maxBytes, or while their newest message is older than maxAge.
+// The segment being written is never deleted. Zero disables a limit.
+func WithFileStoreRetention(maxBytes int64, maxAge time.Duration) FileStoreOption {
+	return func(s *FileStore) {
+		s.maxBytes = maxBytes
+		s.maxAge = maxAge
+	}
+}
+
+// OpenFileStore opens the store in dir, creating the directory if needed.
+// A record left incomplete or corrupt by a crash at the end of the last
+// segment is cut off, along with anything after it. Damage to an earlier
+// segment cannot come from a crash, so it is an error. Ids it assigns are decimal sequence
+// numbers, which continue across restarts.
+func OpenFileStore(dir string, opts ...FileStoreOption) (*FileStore, error) {
+	s := &FileStore{
+		dir:          dir,
+		segmentSize:  defaultSegmentSize,
+		syncInterval: time.Second,
+		now:          time.Now,
+		index:        make(map[string]fileLocation),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(paths)
+
+	for i, path := range paths {
+		if err := s.loadSegment(path, i == len(paths)-1); err != nil {
+			s.closeFiles()
+			return nil, err
+		}
+	}
+	if len(s.segments) == 0 {
+		if err := s.newSegment(); err != nil {
+			return nil, err
+		}
+	}
+	s.enforceRetention()
+
+	if s.syncPolicy == SyncInterval && s.syncInterval > 0 {
+		s.stop = make(chan struct{})
+		s.done = make(chan struct{})
+		go s.syncLoop()
+	}
+
+	return s, nil
+}
+
+// loadSegment opens a segment and indexes its records. The last segment is
+// truncated after its last intact record; any other must be intact.
+func (s *FileStore) loadSegment(path string, last bool) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	seg := &fileSegment{path: path, f: f}
+	s.segments = append(s.segments, seg)
+
+	var (
+		rec fileRecord
+		msg Message
+	)
+	dec := NewDecoder(bytes.NewReader(nil))
+	br := bufio.NewReader(io.NewSectionReader(f, 0, info.Size()))
+	for {
+		err := rec.read(br)
+		if err == nil {
+			dec.Reset(bytes.NewReader(rec.payload))
+			if err = dec.Decode(&msg); err != nil {
+				err = fmt.Errorf("%w: %w", errCorruptRecord, err)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if !last {
+				return fmt.Errorf("%w in %s at offset %d", err, path, seg.size)
+			}
+			break
+		}
+
+		seg.size += fileRecordHeader + int64(len(rec.payload))
+		seg.lastAt = rec.at
+		seg.ids = append(seg.ids, msg.Id)
+		s.index[msg.Id] = fileLocation{seg: seg, next: seg.size}
+		s.seq = max(s.seq, rec.seq)
+	}
+	s.size += seg.size
+
+	if seg.size < info.Size() {
+		if err := f.Truncate(seg.size); err != nil {
+			return err
+		}
+		return f.Sync()
+	}
+	return nil
+}
+
+// newSegment starts a segment named after the sequence number of its first
+// record, so names sort in append order.
+func (s *FileStore) newSegment() error {
+	path := filepath.Join(s.dir, fmt.Sprintf("%020d.log", s.seq+1))
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	// The file's data is synced on append, but its name only once the
+	// directory is.
+	if err := s.syncDir(); err != nil {
+		f.Close()
+		_ = os.Remove(path)
+		return err
+	}
+	s.segments = append(s.segments, &fileSegment{path: path, f: f})
+	return nil
+}
+
+// syncDir syncs the directory, making segments created or deleted in it
+// durable, unless the sync policy is SyncNever.
+func (s *FileStore) syncDir() error {
+	if s.syncPolicy == SyncNever {
+		return nil
+	}
+	d, err := os.Open(s.dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Append writes msg to the current segment. See EventStore. A message over
+// 64 MiB once encoded is rejected with an error wrapping ErrMessageTooLarge.
+func (s *FileStore) Append(msg *Message) error {
+	if err := validateMessage(msg); err != nil {
+		return err
+	}
+	// The encoded record holds at least these bytes, so a message over the
+	// limit is rejected before it is copied into the buffer.
+	if n := len(msg.Id) + len(msg.Event) + len(msg.Data) + len(msg.Comment); n > maxFileRecord {
+		return fmt.Errorf("%w: %d bytes of fields, the store's limit is %d", ErrMessageTooLarge, n, maxFileRecord)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return os.ErrClosed
+	}
+
+	seg := s.segments[len(s.segments)-1]
+	if seg.size >= s.segmentSize {
+		if s.syncPolicy != SyncNever {
+			if err := seg.f.Sync(); err != nil {
+				return err
+			}
+		}
+		if err := s.newSegment(); err != nil {
+			return err
+		}
+		seg = s.segments[len(s.segments)-1]
+	}
+
+	seq := s.seq + 1
+	stored := *msg
+	if stored.Id == "" {
+		stored.Id = strconv.FormatUint(seq, 10)
+	}
+	now := s.now()
+
+	s.buf.Reset()
+	s.buf.Write(make([]byte, fileRecordHeader))
+	if err := encodeMessage(&s.buf, &stored, nil, false); err != nil {
+		return err
+	}
+	record := s.buf.Bytes()
+	binary.LittleEndian.PutUint32(record[0:], uint32(len(record)-fileRecordHeader))
+	binary.LittleEndian.PutUint64(record[8:], seq)
+	binary.LittleEndian.PutUint64(record[16:], uint64(now.UnixNano()))
+	binary.LittleEndian.PutUint32(record[4:], crc32.Checksum(record[8:], crc32c))
+	if cap(record) > maxPushBufferRetain {
+		defer func() { s.buf = bytes.Buffer{} }()
+	}
+	if n := len(record) - fileRecordHeader; n > maxFileRecord {
+		return fmt.Errorf("%w: %d bytes encoded, the store's limit is %d", ErrMessageTooLarge, n, maxFileRecord)
+	}
+
+	if _, err := seg.f.WriteAt(record, seg.size); err != nil {
+		// Do not leave a partial record for the next append to follow.
+		_ = seg.f.Truncate(seg.size)
+		return err
+	}
+	if s.syncPolicy == SyncAlways {
+		if err := seg.f.Sync(); err != nil {
+			return err
+		}
+	} else {
+		s.dirty = true
+	}
+
+	s.seq = seq
+	seg.size += int64(len(record))
+	s.size += int64(len(record))
+	seg.lastAt = now
+	seg.ids = append(seg.ids, stored.Id)
+	s.index[stored.Id] = fileLocation{seg: seg, next: seg.size}
+	msg.Id = stored.Id
+
+	s.enforceRetention()
+	return nil
+}
+
+// enforceRetention deletes the oldest segments that are over the limits.
+func (s *FileStore) enforceRetention() {
+	deleted := false
+	defer func() {
+		if deleted {
+			_ = s.syncDir()
+		}
+	}()
+
+	now := s.now()
+	for len(s.segments) > 1 {
+		oldest := s.segments[0]
+		overSize := s.maxBytes > 0 && s.size > s.maxBytes
+		overAge := s.maxAge > 0 && now.Sub(oldest.lastAt) > s.maxAge
+		if !overSize && !overAge {
+			return
+		}
+
+		for _, id := range oldest.ids {
+			// A later message may have reused the id.
+			if s.index[id].seg == oldest {
+				delete(s.index, id)
+			}
+		}
+		if oldest.readers.Load() > 0 {
+			oldest.retired = true
+		} else {
+			if oldest.delete() == nil {
+				deleted = true
+			}
+		}
+		s.size -= oldest.size
+		s.segments[0] = nil
+		s.segments = s.segments[1:]
+	}
+}
+
+// ReplayAfter reads back the messages after lastID. See EventStore. Only
+// what was stored when it was called is replayed; fn runs without holding
+// up appends.
+func (s *FileStore) ReplayAfter(lastID string, fn func(msg *Message) error) error {
+	spans, err := s.replaySpans(lastID)
+	if err != nil {
+		return err
+	}
+	defer s.release(spans)
+
+	var rec fileRecord
+	dec := NewDecoder(bytes.NewReader(nil))
+	for _, span := range spans {
+		br := bufio.NewReader(io.NewSectionReader(span.seg.f, span.start, span.end-span.start))
+		for {
+			err := rec.read(br)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("sse: reading %s: %w", span.seg.path, err)
+			}
+			msg := &Message{}
+			dec.Reset(bytes.NewReader(rec.payload))
+			if err := dec.Decode(msg); err != nil {
+				return fmt.Errorf("sse: reading %s: %w", span.seg.path, err)
+			}
+			if err := fn(msg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// replaySpans returns what ReplayAfter has to read, marking the segments as
+// being read so retention does not delete them meanwhile.
+func (s *FileStore) replaySpans(lastID string) ([]fileSpan, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return nil, os.ErrClosed
+	}
+
+	first, offset := 0, int64(0)
+	if lastID != "" {
+		loc, ok := s.index[lastID]
+		if !ok {
+			return nil, ErrReplayGap
+		}
+		first = slices.Index(s.segments, loc.seg)
+		if first < 0 {
+			return nil, ErrReplayGap
+		}
+		offset = loc.next
+	}
+
+	spans := make([]fileSpan, 0, len(s.segments)-first)
+	for _, seg := range s.segments[first:] {
+		seg.readers.Add(1)
+		spans = append(spans, fileSpan{seg: seg, start: offset, end: seg.size})
+		offset = 0
+	}
+	return spans, nil
+}
+
+// release ends a replay of spans, deleting segments retired meanwhile.
+func (s *FileStore) release(spans []fileSpan) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := false
+	for _, span := range spans {
+		if span.seg.readers.Add(-1) == 0 && span.seg.retired {
+			if span.seg.delete() == nil {
+				deleted = true
+			}
+		}
+	}
+	if deleted {
+		_ = s.syncDir()
+	}
+}
+
+// Close syncs and closes the segment files. Further calls fail with
+// os.ErrClosed.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return os.ErrClosed
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	if s.syncPolicy != SyncNever && s.dirty {
+		err = s.segments[len(s.segments)-1].f.Sync()
+	}
+	if cerr := s.closeFiles(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (s *FileStore) closeFiles() error {
+	var err error
+	for _, seg := range s.segments {
+		if cerr := seg.f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (s *FileStore) syncLoop() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			if s.dirty {
+				if err := s.segments[len(s.segments)-1].f.Sync(); err == nil {
+					s.dirty = false
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// fileRecord is a record read back from a segment. payload is reused across
+// reads.
+type fileRecord struct {
+	header  [fileRecordHeader]byte
+	seq     uint64
+	at      time.Time
+	payload []byte
+}
+
+// read reads the next record from r. It returns io.EOF at a clean end of
+// input and an error wrapping errCorruptRecord for a partial or damaged
+// record.
+func (rec *fileRecord) read(r io.Reader) error {
+	if _, err := io.ReadFull(r, rec.header[:]); err != nil {
+		if err == io.EOF {
+			return io.EOF
+		}
+		return fmt.Errorf("%w: %w", errCorruptRecord, err)
+	}
+
+	n := binary.LittleEndian.Uint32(rec.header[0:])
+	if n > maxFileRecord {
+		return fmt.Errorf("%w: length %d", errCorruptRecord, n)
+	}
+	rec.payload = grow(rec.payload[:0], int(n))[:n]
+	if _, err := io.ReadFull(r, rec.payload); err != nil {
+		return fmt.Errorf("%w: %w", errCorruptRecord, err)
+	}
+
+	crc := crc32.Update(crc32.Checksum(rec.header[8:], crc32c), crc32c, rec.payload)
+	if crc != binary.LittleEndian.Uint32(rec.header[4:]) {
+		return fmt.Errorf("%w: checksum mismatch", errCorruptRecord)
+	}
+
+	rec.seq = binary.LittleEndian.Uint64(rec.header[8:])
+	rec.at = time.Unix(0, int64(binary.LittleEndian.Uint64(rec.header[16:])))
+	return nil
+}
@@ -0,0 +1,305 @@
+package sse
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func appendMessages(t *testing.T, s EventStore, data ...string) {
+	t.Helper()
+
+	for _, d := range data {
+		if err := s.Append(&Message{Event: "e", Data: d}); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+}
+
+func TestFileStoreReopen(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	s, err := OpenFileStore(dir, WithFileStoreSync(SyncInterval, time.Millisecond))
+	if err != nil {
+		t.Fatalf("OpenFileStore() error = %v", err)
+	}
+	appendMessages(t, s, "a", "b", "c")
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := s.Append(&Message{Data: "x"}); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("Append() after Close error = %v, want %v", err, os.ErrClosed)
+	}
+
+	s, err = OpenFileStore(dir)
+	if err != nil {
+		t.Fatalf("OpenFileStore() reopening error = %v", err)
+	}
+	defer s.Close()
+
+	msg := &Message{Data: "d\nmultiline", Comment: "note", Retry: time.Second}
+	if err := s.Append(msg); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	if msg.Id != "4" {
+		t.Fatalf("Append() assigned Id %q after reopening, want %q", msg.Id, "4")
+	}
+
+	var got []Message
+	err = s.ReplayAfter("2", func(msg *Message) error {
+		got = append(got, *msg)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReplayAfter() error = %v", err)
+	}
+	want := []Message{
+		{Id: "3", Event: "e", Data: "c"},
+		{Id: "4", Data: "d\nmultiline", Comment: "note", Retry: time.Second},
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("ReplayAfter() = %#v, want %#v", got, want)
+	}
+
+	if _, err := replayIDs(t, s, "missing"); !errors.Is(err, ErrReplayGap) {
+		t.Fatalf("ReplayAfter(missing) error = %v, want %v", err, ErrReplayGap)
+	}
+}
+
+func TestFileStoreRejectsOversizedMessage(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	s, err := OpenFileStore(dir, WithFileStoreSync(SyncNever, 0))
+	if err != nil {
+		t.Fatalf("OpenFileStore() error = %v", err)
+	}
+	appendMessages(t, s, "a")
+	// The first is only over the limit once encoded; the second is caught
+	// before encoding.
+	for _, n := range []int{maxFileRecord, maxFileRecord + 1} {
+		if err := s.Append(&Message{Data: strings.Repeat("x", n)}); !errors.Is(err, ErrMessageTooLarge) {
+			t.Fatalf("Append(%d bytes) error = %v, want %v", n, err, ErrMessageTooLarge)
+		}
+	}
+	appendMessages(t, s, "b")
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// Nothing was written that reopening would have to cut off.
+	s, err = OpenFileStore(dir)
+	if err != nil {
+		t.Fatalf("OpenFileStore() reopening error = %v", err)
+	}
+	defer s.Close()
+
+	ids, err := replayIDs(t, s, "")
+	if err != nil {
+		t.Fatalf("ReplayAfter() error = %v", err)
+	}
+	if want := []string{"1", "2"}; !slices.Equal(ids, want) {
+		t.Fatalf("ReplayAfter() ids = %q, want %q", ids, want)
+	}
+}
+
+func TestFileStoreRecovery(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		damage  func(data []byte) []byte
+		wantIDs []string
+	}{
+		{
+			name: "torn header",
+			damage: func(data []byte) []byte {
+				return append(data, 1, 2, 3)
+			},
+			wantIDs: []string{"1", "2", "3"},
+		},
+		{
+			name: "torn payload",
+			damage: func(data []byte) []byte {
+				return data[:len(data)-2]
+			},
+			wantIDs: []string{"1", "2"},
+		},
+		{
+			name: "checksum mismatch",
+			damage: func(data []byte) []byte {
+				data[len(data)-3] ^= 0xff
+				return data
+			},
+			wantIDs: []string{"1", "2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			s, err := OpenFileStore(dir)
+			if err != nil {
+				t.Fatalf("OpenFileStore() error = %v", err)
+			}
+			appendMessages(t, s, "a", "b", "c")
+			_ = s.Close()
+
+			paths, _ := filepath.Glob(filepath.Join(dir, "*.log"))
+			if len(paths) != 1 {
+				t.Fatalf("segments = %v, want 1", paths)
+			}
+			data, err := os.ReadFile(paths[0])
+			if err != nil {
+				t.Fatalf("ReadFile() error = %v", err)
+			}
+			if err := os.WriteFile(paths[0], tt.damage(data), 0o644); err != nil {
+				t.Fatalf("WriteFile() error = %v", err)
+			}
+
+			s, err = OpenFileStore(dir)
+			if err != nil {
+				t.Fatalf("OpenFileStore() after damage error = %v", err)
+			}
+			defer s.Close()
+
+			// Appending after recovery must continue from the last intact
+			// record rather than after the damaged bytes.
+			appendMessages(t, s, "d")
+			want := append(slices.Clone(tt.wantIDs), strconv.Itoa(len(tt.wantIDs)+1))
+			got, err := replayIDs(t, s, "")
+			if err != nil {
+				t.Fatalf("ReplayAfter() error = %v", err)
+			}
+			if !slices.Equal(got, want) {
+				t.Fatalf("ReplayAfter() ids = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestFileStoreCorruptEarlierSegment(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	// Each message fills a segment, so the three land in separate files.
+	s, err := OpenFileStore(dir, WithFileStoreSegmentSize(1))
+	if err != nil {
+		t.Fatalf("OpenFileStore() error = %v", err)
+	}
+	appendMessages(t, s, "a", "b", "c")
+	_ = s.Close()
+
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.log"))
+	if len(paths) != 3 {
+		t.Fatalf("segments = %v, want 3", paths)
+	}
+	data, err := os.ReadFile(paths[1])
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	data[len(data)-3] ^= 0xff
+	if err := os.WriteFile(paths[1], data, 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	// Only the last segment can have been torn by a crash; the damaged
+	// middle one must not be cut short, which would lose its messages.
+	if _, err := OpenFileStore(dir); !errors.Is(err, errCorruptRecord) {
+		t.Fatalf("OpenFileStore() error = %v, want %v", err, errCorruptRecord)
+	}
+	if info, err := os.Stat(paths[1]); err != nil || info.Size() != int64(len(data)) {
+		t.Fatalf("damaged segment was modified: %v, %v", info, err)
+	}
+}
+
+func TestFileStoreRetention(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1000, 0)
+	dir := t.TempDir()
+	// Each message fills a segment, so every append starts a new one.
+	s, err := OpenFileStore(dir,
+		WithFileStoreSegmentSize(1),
+		WithFileStoreRetention(200, time.Minute),
+		WithFileStoreSync(SyncNever, 0),
+	)
+	if err != nil {
+		t.Fatalf("OpenFileStore() error = %v", err)
+	}
+	defer s.Close()
+	s.now = func() time.Time { return now }
+
+	appendMessages(t, s, "1", "2", "3", "4", "5", "6")
+	got, err := replayIDs(t, s, "")
+	if err != nil {
+		t.Fatalf("ReplayAfter() error = %v", err)
+	}
+	// Records are 48 bytes, so four fit in 200.
+	if !slices.Equal(got, []string{"3", "4", "5", "6"}) {
+		t.Fatalf("ids after size retention = %q, want [3 4 5 6]", got)
+	}
+	if _, err := replayIDs(t, s, "2"); !errors.Is(err, ErrReplayGap) {
+		t.Fatalf("ReplayAfter(2) error = %v, want %v", err, ErrReplayGap)
+	}
+
+	now = now.Add(2 * time.Minute)
+	appendMessages(t, s, "7")
+	if got, _ := replayIDs(t, s, ""); !slices.Equal(got, []string{"7"}) {
+		t.Fatalf("ids after age retention = %q, want [7]", got)
+	}
+	if paths, _ := filepath.Glob(filepath.Join(dir, "*.log")); len(paths) != 1 {
+		t.Fatalf("segment files = %d, want 1", len(paths))
+	}
+}
+
+func TestFileStoreReplayDoesNotBlockAppend(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	// Each message fills a segment, and only four are kept.
+	s, err := OpenFileStore(dir,
+		WithFileStoreSegmentSize(1),
+		WithFileStoreRetention(200, 0),
+		WithFileStoreSync(SyncNever, 0),
+	)
+	if err != nil {
+		t.Fatalf("OpenFileStore() error = %v", err)
+	}
+	defer s.Close()
+
+	appendMessages(t, s, "1", "2", "3")
+
+	// Appending from fn would deadlock if the replay held the lock. The
+	// appends retire every segment being read, which must still be read in
+	// full and deleted afterwards.
+	var got []string
+	err = s.ReplayAfter("", func(msg *Message) error {
+		if len(got) == 0 {
+			appendMessages(t, s, "4", "5", "6", "7", "8")
+		}
+		got = append(got, msg.Id)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReplayAfter() error = %v", err)
+	}
+	if want := []string{"1", "2", "3"}; !slices.Equal(got, want) {
+		t.Fatalf("ReplayAfter() ids = %q, want %q", got, want)
+	}
+
+	if paths, _ := filepath.Glob(filepath.Join(dir, "*.log")); len(paths) != 4 {
+		t.Fatalf("segment files = %d, want 4", len(paths))
+	}
+	if got, _ := replayIDs(t, s, ""); !slices.Equal(got, []string{"5", "6", "7", "8"}) {
+		t.Fatalf("ids after replay = %q, want [5 6 7 8]", got)
+	}
+}
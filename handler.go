@@ -0,0 +1,144 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// Stream is the server side of one SSE connection, passed to the function
+// given to Handler. It implements Pusher, so it can be subscribed to a
+// Broker; closing it, as a Broker does with a failed pusher, ends the
+// handler's context. So does a send queue giving up on a slow client.
+type Stream struct {
+	pusher      *HttpPusher
+	req         *http.Request
+	lastEventID string
+	cancel      context.CancelFunc
+}
+
+var _ Pusher = (*Stream)(nil)
+
+// LastEventID returns the Last-Event-ID header sent by a reconnecting
+// client, or "" for a new one.
+func (s *Stream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Request returns the request that opened the stream.
+func (s *Stream) Request() *http.Request {
+	return s.req
+}
+
+func (s *Stream) Push(msg *Message) error {
+	return s.pusher.Push(msg)
+}
+
+// PushFrame writes a frame encoded earlier. See HttpPusher.PushFrame.
+func (s *Stream) PushFrame(f *Frame) error {
+	return s.pusher.PushFrame(f)
+}
+
+// Close ends the stream and cancels the handler's context.
+func (s *Stream) Close() error {
+	s.cancel()
+	return s.pusher.Close()
+}
+
+type streamHandler struct {
+	fn         func(ctx context.Context, stream *Stream) error
+	pusherOpts []HttpPusherOption
+	onError    func(r *http.Request, err error)
+}
+
+type HandlerOption func(*streamHandler)
+
+// WithHandlerPusher sets options for the HttpPusher behind each stream, such
+// as WithHttpPusherPingDuration.
+func WithHandlerPusher(opts ...HttpPusherOption) HandlerOption {
+	return func(h *streamHandler) {
+		h.pusherOpts = append(h.pusherOpts, opts...)
+	}
+}
+
+// WithHandlerOnError sets the callback for errors returned by the stream
+// function and for panics in it, which are reported as errors wrapping
+// ErrHandlerPanic. By default they are logged with slog.Default.
+func WithHandlerOnError(onError func(r *http.Request, err error)) HandlerOption {
+	return func(h *streamHandler) {
+		h.onError = onError
+	}
+}
+
+func defaultHandlerOnError(r *http.Request, err error) {
+	slog.Default().ErrorContext(r.Context(), "sse: stream failed",
+		slog.String("path", r.URL.Path),
+		slog.Any("error", err),
+	)
+}
+
+// Handler returns an http.Handler that opens an SSE stream for each request
+// and runs fn with it. The context passed to fn is done when the client
+// disconnects or the stream is closed; fn should return then. Once fn
+// returns, the stream is closed.
+//
+// Headers are sent before fn is called, so fn cannot change the status;
+// reject requests, for example on failed authentication, in middleware in
+// front of the handler. Errors from fn are passed to the OnError callback,
+// except those after the client went away or the stream was closed, which
+// are the normal end of a stream.
+func Handler(fn func(ctx context.Context, stream *Stream) error, opts ...HandlerOption) http.Handler {
+	h := &streamHandler{
+		fn:      fn,
+		onError: defaultHandlerOnError,
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	return h
+}
+
+func (h *streamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pusher, err := CreateHttpPusher(w, h.pusherOpts...)
+	if err != nil {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		h.onError(r, err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	pusher.onAbort = cancel
+	stream := &Stream{
+		pusher:      pusher,
+		req:         r,
+		lastEventID: r.Header.Get("Last-Event-ID"),
+		cancel:      cancel,
+	}
+	defer stream.Close()
+
+	err = h.run(ctx, stream)
+	if err == nil {
+		return
+	}
+	if !errors.Is(err, ErrHandlerPanic) && (ctx.Err() != nil || errors.Is(err, http.ErrServerClosed)) {
+		return
+	}
+	h.onError(r, err)
+}
+
+func (h *streamHandler) run(ctx context.Context, stream *Stream) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			err = fmt.Errorf("%w: %v\n%s", ErrHandlerPanic, p, debug.Stack())
+		}
+	}()
+	return h.fn(ctx, stream)
+}
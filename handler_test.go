@@ -0,0 +1,131 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	errFailed := errors.New("failed")
+
+	var (
+		mu     sync.Mutex
+		errs   []error
+		lastID []string
+	)
+	handler := Handler(func(ctx context.Context, stream *Stream) error {
+		mu.Lock()
+		lastID = append(lastID, stream.LastEventID())
+		mu.Unlock()
+
+		switch stream.Request().URL.Query().Get("mode") {
+		case "panic":
+			panic("boom")
+		case "fail":
+			return errFailed
+		}
+		if stream.LastEventID() == "" {
+			return stream.Push(&Message{Id: "1", Data: "first"})
+		}
+		if err := stream.Push(&Message{Id: "2", Data: "resumed"}); err != nil {
+			return err
+		}
+		// Wait for the client to go away; the error that follows is not
+		// reported.
+		<-ctx.Done()
+		return ctx.Err()
+	}, WithHandlerOnError(func(r *http.Request, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs = append(errs, err)
+	}))
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	receiver, err := CreateHttpReceiver(
+		server.URL,
+		WithHttpReceiverClient(server.Client()),
+		WithHttpReceiverRetry(3, time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("CreateHttpReceiver() error = %v", err)
+	}
+	for _, want := range []string{"first", "resumed"} {
+		msg, err := receiver.Receive()
+		if err != nil {
+			t.Fatalf("Receive() error = %v", err)
+		}
+		if msg.Data != want {
+			t.Fatalf("Receive() data = %q, want %q", msg.Data, want)
+		}
+	}
+	_ = receiver.Close()
+
+	for _, mode := range []string{"panic", "fail"} {
+		resp, err := server.Client().Get(server.URL + "?mode=" + mode)
+		if err != nil {
+			t.Fatalf("GET ?mode=%s error = %v", mode, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("GET ?mode=%s = %d %q, want an event stream", mode, resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+	}
+	server.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(lastID) < 2 || lastID[0] != "" || lastID[1] != "1" {
+		t.Fatalf("LastEventID() values = %q, want [\"\" \"1\" ...]", lastID)
+	}
+	if len(errs) != 2 || !errors.Is(errs[0], ErrHandlerPanic) || !errors.Is(errs[1], errFailed) {
+		t.Fatalf("reported errors = %v, want a panic and %v", errs, errFailed)
+	}
+}
+
+func TestHandlerStreamClosedByBroker(t *testing.T) {
+	t.Parallel()
+
+	broker := NewBroker()
+	subscribed := make(chan struct{})
+	done := make(chan error, 1)
+
+	server := httptest.NewServer(Handler(func(ctx context.Context, stream *Stream) error {
+		if err := broker.Subscribe("t", stream); err != nil {
+			return err
+		}
+		close(subscribed)
+		<-ctx.Done()
+		done <- ctx.Err()
+		return nil
+	}))
+	defer server.Close()
+
+	receiver, err := CreateHttpReceiver(server.URL, WithHttpReceiverClient(server.Client()))
+	if err != nil {
+		t.Fatalf("CreateHttpReceiver() error = %v", err)
+	}
+	defer receiver.Close()
+
+	<-subscribed
+	if err := broker.Publish("t", &Message{Data: "live"}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	msg, err := receiver.Receive()
+	if err != nil || msg.Data != "live" {
+		t.Fatalf("Receive() = %v, %v, want data=live", msg, err)
+	}
+
+	_ = broker.Close()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("handler context error = %v, want %v", err, context.Canceled)
+	}
+}
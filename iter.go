@@ -0,0 +1,140 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"net/http"
+)
+
+// BufferPolicy decides what HttpReceiver.Messages does with a message when
+// the consumer has let the channel fill up.
+type BufferPolicy int
+
+const (
+	// BufferBlock waits for the consumer, which stops reading from the
+	// connection in the meantime. This is the default.
+	BufferBlock BufferPolicy = iota
+	// BufferDropOldest discards the oldest buffered message to make room,
+	// so a slow consumer sees the most recent ones.
+	BufferDropOldest
+	// BufferDisconnect ends the subscription with ErrSlowConsumer.
+	BufferDisconnect
+)
+
+// ErrSlowConsumer ends a subscription from HttpReceiver.Messages whose
+// buffer filled up under BufferDisconnect, and closes an HttpPusher whose
+// send queue overflowed under OverflowDisconnect or OverflowBlock with a
+// timeout.
+var ErrSlowConsumer = errors.New("sse: consumer too slow")
+
+// All returns an iterator over the messages received by r:
+//
+//	for msg, err := range sse.All(ctx, receiver) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// The iterator owns r and closes it when the loop ends, whether by break,
+// by an error or by r being closed elsewhere. Closing r ends the loop
+// without an error. Any other error is yielded and ends the loop, except
+// ErrMessageTooLarge, after which the receiver skips the message and the
+// loop goes on.
+func All(ctx context.Context, r Receiver) iter.Seq2[*Message, error] {
+	return func(yield func(*Message, error) bool) {
+		defer r.Close()
+
+		for {
+			msg, err := r.ReceiveContext(ctx)
+			if errors.Is(err, http.ErrServerClosed) {
+				return
+			}
+			if !yield(msg, err) {
+				return
+			}
+			if err != nil && !errors.Is(err, ErrMessageTooLarge) {
+				return
+			}
+		}
+	}
+}
+
+// All returns an iterator over the messages received by r. See All.
+func (r *HttpReceiver) All(ctx context.Context) iter.Seq2[*Message, error] {
+	return All(ctx, r)
+}
+
+// Messages receives on a background goroutine into a channel buffering up
+// to bufferSize messages, so parsing keeps going while the consumer is busy.
+// What happens when the buffer is full is set by
+// WithHttpReceiverBufferPolicy.
+//
+// Like All, the goroutine owns r and closes it when it stops, after which
+// both channels are closed. It stops when ctx is done, when r is closed or
+// when receiving fails; in the last case the error is sent on the error
+// channel first. Oversized messages are skipped.
+func (r *HttpReceiver) Messages(ctx context.Context, bufferSize int) (<-chan *Message, <-chan error) {
+	msgs := make(chan *Message, max(bufferSize, 0))
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(msgs)
+		defer r.Close()
+
+		for {
+			msg, err := r.ReceiveContext(ctx)
+			if err == nil {
+				err = r.deliver(ctx, msgs, msg)
+			}
+			switch {
+			case err == nil, errors.Is(err, ErrMessageTooLarge):
+				continue
+			case errors.Is(err, http.ErrServerClosed), ctx.Err() != nil:
+				return
+			}
+			errs <- err
+			return
+		}
+	}()
+
+	return msgs, errs
+}
+
+// deliver sends msg on msgs according to the buffer policy.
+func (r *HttpReceiver) deliver(ctx context.Context, msgs chan *Message, msg *Message) error {
+	switch r.bufferPolicy {
+	case BufferDropOldest:
+		for {
+			select {
+			case msgs <- msg:
+				return nil
+			default:
+			}
+			// Make room, unless the consumer just did.
+			select {
+			case <-msgs:
+			default:
+				if cap(msgs) == 0 {
+					return nil
+				}
+			}
+		}
+	case BufferDisconnect:
+		select {
+		case msgs <- msg:
+			return nil
+		default:
+			return ErrSlowConsumer
+		}
+	default:
+		select {
+		case msgs <- msg:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
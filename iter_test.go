@@ -0,0 +1,216 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// scriptedReceiver returns a fixed sequence of results, then
+// http.ErrServerClosed.
+type scriptedReceiver struct {
+	results []recvResult
+	closed  bool
+}
+
+func (r *scriptedReceiver) Receive() (*Message, error) {
+	return r.ReceiveContext(context.Background())
+}
+
+func (r *scriptedReceiver) ReceiveContext(ctx context.Context) (*Message, error) {
+	if r.closed || len(r.results) == 0 {
+		return nil, http.ErrServerClosed
+	}
+	res := r.results[0]
+	r.results = r.results[1:]
+	return res.msg, res.err
+}
+
+func (r *scriptedReceiver) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestAll(t *testing.T) {
+	t.Parallel()
+
+	errBroken := errors.New("broken")
+
+	tests := []struct {
+		name    string
+		results []recvResult
+		want    []string // data, or "error: ..." for yielded errors
+	}{
+		{
+			name:    "until closed",
+			results: []recvResult{{msg: &Message{Data: "a"}}, {msg: &Message{Data: "b"}}},
+			want:    []string{"a", "b"},
+		},
+		{
+			name:    "stops on error",
+			results: []recvResult{{msg: &Message{Data: "a"}}, {err: errBroken}, {msg: &Message{Data: "b"}}},
+			want:    []string{"a", "error: broken"},
+		},
+		{
+			name:    "continues after oversized message",
+			results: []recvResult{{err: ErrMessageTooLarge}, {msg: &Message{Data: "b"}}},
+			want:    []string{"error: sse: message too large", "b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := &scriptedReceiver{results: tt.results}
+			var got []string
+			for msg, err := range All(context.Background(), r) {
+				if err != nil {
+					got = append(got, "error: "+err.Error())
+					continue
+				}
+				got = append(got, msg.Data)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("All() yielded %q, want %q", got, tt.want)
+			}
+			if !r.closed {
+				t.Fatal("All() did not close the receiver")
+			}
+		})
+	}
+}
+
+func TestHttpReceiverAllBreakClosesReceiver(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "data: 1\n\ndata: 2\n\ndata: 3\n\n")
+	}))
+	defer server.Close()
+
+	receiver, err := CreateHttpReceiver(server.URL, WithHttpReceiverClient(server.Client()))
+	if err != nil {
+		t.Fatalf("CreateHttpReceiver() error = %v", err)
+	}
+
+	var got []string
+	for msg, err := range receiver.All(context.Background()) {
+		if err != nil {
+			t.Fatalf("All() error = %v", err)
+		}
+		got = append(got, msg.Data)
+		if len(got) == 2 {
+			break
+		}
+	}
+	if !slices.Equal(got, []string{"1", "2"}) {
+		t.Fatalf("All() yielded %q, want [1 2]", got)
+	}
+	if _, err := receiver.Receive(); !errors.Is(err, http.ErrServerClosed) {
+		t.Fatalf("Receive() after break error = %v, want %v", err, http.ErrServerClosed)
+	}
+}
+
+func TestHttpReceiverMessages(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		policy  BufferPolicy
+		want    []string
+		wantErr error // nil expects the 204 StatusError ending the stream
+	}{
+		{name: "block", policy: BufferBlock, want: []string{"1", "2", "3", "4", "5"}},
+		{name: "drop oldest", policy: BufferDropOldest, want: []string{"4", "5"}},
+		{name: "disconnect", policy: BufferDisconnect, want: []string{"1", "2"}, wantErr: ErrSlowConsumer},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var connCount atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if connCount.Add(1) > 1 {
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				w.Header().Set("Content-Type", "text/event-stream")
+				_, _ = io.WriteString(w, "data: 1\n\ndata: 2\n\ndata: 3\n\ndata: 4\n\ndata: 5\n\n")
+			}))
+			defer server.Close()
+
+			receiver, err := CreateHttpReceiver(
+				server.URL,
+				WithHttpReceiverClient(server.Client()),
+				WithHttpReceiverBufferPolicy(tt.policy),
+				WithHttpReceiverRetry(3, time.Millisecond),
+			)
+			if err != nil {
+				t.Fatalf("CreateHttpReceiver() error = %v", err)
+			}
+
+			msgs, errs := receiver.Messages(context.Background(), 2)
+
+			var got []string
+			if tt.policy == BufferBlock {
+				for msg := range msgs {
+					got = append(got, msg.Data)
+				}
+			}
+			// The other policies never wait for the consumer, so the
+			// subscription ends before anything is read.
+			err = <-errs
+			for msg := range msgs {
+				got = append(got, msg.Data)
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("Messages() delivered %q, want %q", got, tt.want)
+			}
+			var statusErr *StatusError
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) ||
+				tt.wantErr == nil && (!errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNoContent) {
+				t.Fatalf("Messages() error = %v, want %v", err, tt.wantErr)
+			}
+			if _, err := receiver.Receive(); !errors.Is(err, http.ErrServerClosed) {
+				t.Fatalf("Receive() after Messages() ended error = %v, want %v", err, http.ErrServerClosed)
+			}
+		})
+	}
+}
+
+func TestHttpReceiverMessagesContextDone(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.(http.Flusher).Flush()
+		<-req.Context().Done()
+	}))
+	defer server.Close()
+
+	receiver, err := CreateHttpReceiver(server.URL, WithHttpReceiverClient(server.Client()))
+	if err != nil {
+		t.Fatalf("CreateHttpReceiver() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	msgs, errs := receiver.Messages(ctx, 1)
+	cancel()
+
+	if _, ok := <-msgs; ok {
+		t.Fatal("Messages() delivered a message, want closed channel")
+	}
+	if err, ok := <-errs; ok {
+		t.Fatalf("Messages() error = %v, want closed channel", err)
+	}
+}
@@ -0,0 +1,229 @@
+package sse
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MessageHandler handles messages routed to it by a ClientMux.
+type MessageHandler interface {
+	ServeMessage(ctx context.Context, msg *Message) error
+}
+
+// MessageHandlerFunc adapts a function to a MessageHandler.
+type MessageHandlerFunc func(ctx context.Context, msg *Message) error
+
+func (f MessageHandlerFunc) ServeMessage(ctx context.Context, msg *Message) error {
+	return f(ctx, msg)
+}
+
+// Middleware wraps a MessageHandler, for example to log or recover.
+type Middleware func(next MessageHandler) MessageHandler
+
+// ClientMux routes received messages to handlers by event name. Messages
+// sent without an event field are routed as "message", as browsers do.
+//
+// A pattern is either an exact event name, a prefix ending in "*" such as
+// "user.*", which matches every event starting with "user.", or "*" alone,
+// which matches any event no other pattern does. Exact names win over
+// prefixes, and longer prefixes over shorter ones. Messages that match no
+// pattern are dropped.
+type ClientMux struct {
+	mu         sync.RWMutex
+	exact      map[string]MessageHandler
+	prefixes   []muxPrefix // longest first
+	fallback   MessageHandler
+	middleware []Middleware
+	chain      MessageHandler // middleware around route
+	onError    func(ctx context.Context, msg *Message, err error) error
+}
+
+type muxPrefix struct {
+	prefix  string
+	handler MessageHandler
+}
+
+func NewClientMux() *ClientMux {
+	m := &ClientMux{
+		exact: make(map[string]MessageHandler),
+	}
+	m.chain = MessageHandlerFunc(m.route)
+	return m
+}
+
+// Handle registers h for pattern, replacing any handler registered for the
+// same pattern.
+func (m *ClientMux) Handle(pattern string, h MessageHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case pattern == "*":
+		m.fallback = h
+	case strings.HasSuffix(pattern, "*"):
+		prefix := strings.TrimSuffix(pattern, "*")
+		i := slices.IndexFunc(m.prefixes, func(p muxPrefix) bool { return p.prefix == prefix })
+		if i >= 0 {
+			m.prefixes[i].handler = h
+			return
+		}
+		m.prefixes = append(m.prefixes, muxPrefix{prefix: prefix, handler: h})
+		slices.SortStableFunc(m.prefixes, func(a, b muxPrefix) int {
+			return len(b.prefix) - len(a.prefix)
+		})
+	default:
+		m.exact[pattern] = h
+	}
+}
+
+// HandleFunc registers f for pattern. See Handle.
+func (m *ClientMux) HandleFunc(pattern string, f func(ctx context.Context, msg *Message) error) {
+	m.Handle(pattern, MessageHandlerFunc(f))
+}
+
+// Use appends middleware run around every message, matched or not, in the
+// order given: the first one added is the outermost.
+func (m *ClientMux) Use(mw ...Middleware) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.middleware = append(m.middleware, mw...)
+	var h MessageHandler = MessageHandlerFunc(m.route)
+	for _, mw := range slices.Backward(m.middleware) {
+		h = mw(h)
+	}
+	m.chain = h
+}
+
+// HandleError sets the policy for errors returned by handlers. onError
+// returns nil to carry on with the next message, or an error to make Serve
+// return it. By default any handler error stops Serve.
+func (m *ClientMux) HandleError(onError func(ctx context.Context, msg *Message, err error) error) {
+	m.mu.Lock()
+	m.onError = onError
+	m.mu.Unlock()
+}
+
+// ServeMessage routes msg through the middleware to its handler and applies
+// the error policy to the result.
+func (m *ClientMux) ServeMessage(ctx context.Context, msg *Message) error {
+	m.mu.RLock()
+	chain, onError := m.chain, m.onError
+	m.mu.RUnlock()
+
+	err := chain.ServeMessage(ctx, msg)
+	if err != nil && onError != nil {
+		err = onError(ctx, msg, err)
+	}
+	return err
+}
+
+func (m *ClientMux) route(ctx context.Context, msg *Message) error {
+	if h := m.Handler(msg.Event); h != nil {
+		return h.ServeMessage(ctx, msg)
+	}
+	return nil
+}
+
+// Handler returns the handler that messages for event are routed to, or nil
+// if there is none.
+func (m *ClientMux) Handler(event string) MessageHandler {
+	if event == "" {
+		event = "message"
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if h, ok := m.exact[event]; ok {
+		return h
+	}
+	for _, p := range m.prefixes {
+		if strings.HasPrefix(event, p.prefix) {
+			return p.handler
+		}
+	}
+	return m.fallback
+}
+
+// Serve receives from r and dispatches each message until ctx is done, r
+// is closed or fails, or the error policy stops it. Messages are handled one
+// at a time, in order. Like All, Serve closes r before returning; it returns
+// nil when r was closed elsewhere.
+func (m *ClientMux) Serve(ctx context.Context, r Receiver) error {
+	for msg, err := range All(ctx, r) {
+		if err != nil {
+			if errors.Is(err, ErrMessageTooLarge) {
+				continue
+			}
+			return err
+		}
+		if err := m.ServeMessage(ctx, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ErrHandlerPanic is wrapped by the error reported for a panicking handler,
+// by Recover and by Handler.
+var ErrHandlerPanic = errors.New("sse: handler panicked")
+
+// Recover turns a panic in a handler into an error wrapping
+// ErrHandlerPanic, which then goes through the error policy like any other.
+func Recover() Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return MessageHandlerFunc(func(ctx context.Context, msg *Message) (err error) {
+			defer func() {
+				if p := recover(); p != nil {
+					err = fmt.Errorf("%w: %v\n%s", ErrHandlerPanic, p, debug.Stack())
+				}
+			}()
+			return next.ServeMessage(ctx, msg)
+		})
+	}
+}
+
+// Logger logs every message with its outcome and how long handling took:
+// at debug level when it succeeds, at error level when it fails.
+func Logger(logger *slog.Logger) Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return MessageHandlerFunc(func(ctx context.Context, msg *Message) error {
+			start := time.Now()
+			err := next.ServeMessage(ctx, msg)
+			attrs := []slog.Attr{
+				slog.String("event", msg.Event),
+				slog.String("id", msg.Id),
+				slog.Duration("duration", time.Since(start)),
+			}
+			if err != nil {
+				attrs = append(attrs, slog.Any("error", err))
+				logger.LogAttrs(ctx, slog.LevelError, "sse: handling message failed", attrs...)
+			} else {
+				logger.LogAttrs(ctx, slog.LevelDebug, "sse: handled message", attrs...)
+			}
+			return err
+		})
+	}
+}
+
+// DecodeJSON returns a handler that unmarshals each message's Data into a
+// T and passes it to fn. Data that is not valid JSON for T is reported as
+// an error without calling fn.
+func DecodeJSON[T any](fn func(ctx context.Context, msg *Message, v T) error) MessageHandler {
+	return MessageHandlerFunc(func(ctx context.Context, msg *Message) error {
+		var v T
+		if err := json.Unmarshal([]byte(msg.Data), &v); err != nil {
+			return fmt.Errorf("sse: decoding %q event: %w", msg.Event, err)
+		}
+		return fn(ctx, msg, v)
+	})
+}
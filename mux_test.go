@@ -0,0 +1,134 @@
+package sse
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestClientMuxRouting(t *testing.T) {
+	t.Parallel()
+
+	var got string
+	handler := func(name string) MessageHandler {
+		return MessageHandlerFunc(func(ctx context.Context, msg *Message) error {
+			got = name
+			return nil
+		})
+	}
+
+	mux := NewClientMux()
+	mux.Handle("message", handler("message"))
+	mux.Handle("user.created", handler("exact"))
+	mux.Handle("user.*", handler("user prefix"))
+	mux.Handle("user.admin.*", handler("admin prefix"))
+	mux.Handle("*", handler("fallback"))
+
+	tests := []struct {
+		event string
+		want  string
+	}{
+		{event: "", want: "message"},
+		{event: "message", want: "message"},
+		{event: "user.created", want: "exact"},
+		{event: "user.deleted", want: "user prefix"},
+		{event: "user.admin.added", want: "admin prefix"},
+		{event: "order.created", want: "fallback"},
+	}
+
+	for _, tt := range tests {
+		got = ""
+		if err := mux.ServeMessage(context.Background(), &Message{Event: tt.event}); err != nil {
+			t.Fatalf("ServeMessage(%q) error = %v", tt.event, err)
+		}
+		if got != tt.want {
+			t.Fatalf("ServeMessage(%q) routed to %q, want %q", tt.event, got, tt.want)
+		}
+	}
+
+	if h := NewClientMux().Handler("anything"); h != nil {
+		t.Fatalf("Handler() on empty mux = %v, want nil", h)
+	}
+}
+
+func TestClientMuxMiddlewareAndErrors(t *testing.T) {
+	t.Parallel()
+
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next MessageHandler) MessageHandler {
+			return MessageHandlerFunc(func(ctx context.Context, msg *Message) error {
+				calls = append(calls, name)
+				return next.ServeMessage(ctx, msg)
+			})
+		}
+	}
+
+	var logs bytes.Buffer
+	mux := NewClientMux()
+	mux.Use(trace("outer"), trace("inner"), Logger(slog.New(slog.NewTextHandler(&logs, nil))), Recover())
+	mux.HandleFunc("boom", func(ctx context.Context, msg *Message) error {
+		panic("boom")
+	})
+
+	var policyErrs []error
+	mux.HandleError(func(ctx context.Context, msg *Message, err error) error {
+		policyErrs = append(policyErrs, err)
+		return nil
+	})
+
+	if err := mux.ServeMessage(context.Background(), &Message{Event: "boom"}); err != nil {
+		t.Fatalf("ServeMessage() error = %v, want nil from the error policy", err)
+	}
+	if !slices.Equal(calls, []string{"outer", "inner"}) {
+		t.Fatalf("middleware calls = %q, want [outer inner]", calls)
+	}
+	if len(policyErrs) != 1 || !errors.Is(policyErrs[0], ErrHandlerPanic) {
+		t.Fatalf("error policy got %v, want one %v", policyErrs, ErrHandlerPanic)
+	}
+	if !strings.Contains(logs.String(), "event=boom") {
+		t.Fatalf("Logger() output = %q, want the failed event logged", logs.String())
+	}
+}
+
+func TestClientMuxServe(t *testing.T) {
+	t.Parallel()
+
+	type tick struct {
+		N int `json:"n"`
+	}
+
+	var ticks []int
+	mux := NewClientMux()
+	mux.Handle("tick", DecodeJSON(func(ctx context.Context, msg *Message, v tick) error {
+		ticks = append(ticks, v.N)
+		return nil
+	}))
+
+	r := &scriptedReceiver{results: []recvResult{
+		{msg: &Message{Event: "tick", Data: `{"n":1}`}},
+		{msg: &Message{Event: "tick", Data: `{"n":2}`}},
+		{msg: &Message{Event: "tick", Data: `not json`}},
+		{msg: &Message{Event: "tick", Data: `{"n":4}`}},
+	}}
+
+	err := mux.Serve(context.Background(), r)
+	if err == nil || !strings.Contains(err.Error(), `decoding "tick" event`) {
+		t.Fatalf("Serve() error = %v, want the decode error", err)
+	}
+	if !slices.Equal(ticks, []int{1, 2}) {
+		t.Fatalf("handled ticks = %v, want [1 2]", ticks)
+	}
+	if !r.closed {
+		t.Fatal("Serve() did not close the receiver")
+	}
+
+	// Once the receiver is closed, Serve returns nil.
+	if err := mux.Serve(context.Background(), &scriptedReceiver{}); err != nil {
+		t.Fatalf("Serve() on closed receiver error = %v, want nil", err)
+	}
+}
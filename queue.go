@@ -0,0 +1,432 @@
+package sse
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// OverflowPolicy decides what an HttpPusher with a send queue does with a
+// message pushed while its queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock makes Push wait for room, for at most the time set by
+	// WithHttpPusherQueueTimeout, after which the pusher is closed with
+	// ErrSlowConsumer. Without a timeout it waits as long as it takes. This
+	// is the default.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest discards the oldest queued message to make room.
+	OverflowDropOldest
+	// OverflowDropNewest discards the message being pushed.
+	OverflowDropNewest
+	// OverflowCoalesce replaces the queued message with the same key, set by
+	// WithHttpPusherCoalesceKey, by the one being pushed, so the client gets
+	// only the latest of each. When no key matches, the oldest queued
+	// message is discarded.
+	OverflowCoalesce
+	// OverflowDisconnect closes the pusher with ErrSlowConsumer.
+	OverflowDisconnect
+)
+
+// QueueStats reports the state of an HttpPusher's send queue.
+type QueueStats struct {
+	Len       int    // messages waiting to be written
+	Cap       int    // size of the queue
+	Sent      uint64 // messages written to the client, pings included
+	Dropped   uint64 // messages discarded by the overflow policy
+	Coalesced uint64 // queued messages replaced by a newer one
+}
+
+type queueItem struct {
+	frame *Frame
+	key   string
+}
+
+// pushQueue sits between the goroutines pushing to an HttpPusher and the
+// goroutine writing to its connection.
+type pushQueue struct {
+	mu       sync.Mutex
+	items    []queueItem // ring buffer
+	head     int         // index of the oldest item
+	size     int
+	policy   OverflowPolicy
+	timeout  time.Duration
+	key      func(msg *Message) string
+	ready    chan struct{} // wakes the writer; buffered by one
+	room     chan struct{} // closed when the writer takes items, if anyone waits
+	closed   bool
+	err      error         // why the queue closed early, or nil
+	released bool          // the pusher is closed; the connection must not be touched
+	done     chan struct{} // closed when the writer exits
+
+	sent, dropped, coalesced uint64
+}
+
+func defaultCoalesceKey(msg *Message) string {
+	return msg.Event
+}
+
+// pushQueue returns the queue settings, creating them for the first
+// option that needs them. The queue starts in CreateHttpPusher.
+func (p *HttpPusher) pushQueue() *pushQueue {
+	if p.queue == nil {
+		p.queue = &pushQueue{key: defaultCoalesceKey}
+	}
+	return p.queue
+}
+
+// WithHttpPusherQueue gives the pusher a queue of up to size messages,
+// written to the client by a goroutine of its own, so Push and PushFrame
+// return without waiting for the network. policy decides what happens when
+// the client falls size messages behind.
+//
+// Messages are encoded when pushed, so invalid ones are still rejected by
+// Push, but a failed write is only reported by the pushes after it.
+func WithHttpPusherQueue(size int, policy OverflowPolicy) HttpPusherOption {
+	return func(p *HttpPusher) {
+		q := p.pushQueue()
+		q.items = make([]queueItem, max(size, 0))
+		q.policy = policy
+	}
+}
+
+// WithHttpPusherQueueTimeout bounds how long Push waits for room under
+// OverflowBlock, and how long Close waits for queued messages to be
+// written before discarding them.
+func WithHttpPusherQueueTimeout(d time.Duration) HttpPusherOption {
+	return func(p *HttpPusher) {
+		p.pushQueue().timeout = d
+	}
+}
+
+// WithHttpPusherCoalesceKey sets the key that OverflowCoalesce matches
+// messages by. Messages with an empty key are never replaced. The default
+// key is the event name.
+func WithHttpPusherCoalesceKey(key func(msg *Message) string) HttpPusherOption {
+	return func(p *HttpPusher) {
+		p.pushQueue().key = key
+	}
+}
+
+// QueueStats returns the current state of the send queue. It is all zeros
+// for a pusher without one.
+func (p *HttpPusher) QueueStats() QueueStats {
+	q := p.queue
+	if q == nil {
+		return QueueStats{}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return QueueStats{
+		Len:       q.size,
+		Cap:       len(q.items),
+		Sent:      q.sent,
+		Dropped:   q.dropped,
+		Coalesced: q.coalesced,
+	}
+}
+
+func (p *HttpPusher) startQueue() {
+	q := p.queue
+	if len(q.items) == 0 {
+		p.queue = nil
+		return
+	}
+	q.ready = make(chan struct{}, 1)
+	q.done = make(chan struct{})
+	go p.writeLoop()
+}
+
+func (p *HttpPusher) enqueue(f *Frame) error {
+	q := p.queue
+	var key string
+	if q.policy == OverflowCoalesce {
+		key = q.key(&f.msg)
+	}
+
+	var timeout <-chan time.Time
+	q.mu.Lock()
+	for {
+		if q.closed {
+			err := q.closeErr()
+			q.mu.Unlock()
+			return err
+		}
+		if q.size < len(q.items) {
+			q.items[(q.head+q.size)%len(q.items)] = queueItem{frame: f, key: key}
+			q.size++
+			q.mu.Unlock()
+			q.wake()
+			return nil
+		}
+
+		switch q.policy {
+		case OverflowDropOldest:
+			q.dropOldest()
+			continue
+		case OverflowDropNewest:
+			q.dropped++
+			q.mu.Unlock()
+			return nil
+		case OverflowCoalesce:
+			if q.coalesce(key, f) {
+				q.mu.Unlock()
+				return nil
+			}
+			q.dropOldest()
+			continue
+		case OverflowDisconnect:
+			q.mu.Unlock()
+			return p.abort(ErrSlowConsumer)
+		}
+
+		if q.room == nil {
+			q.room = make(chan struct{})
+		}
+		room := q.room
+		q.mu.Unlock()
+
+		if timeout == nil && q.timeout > 0 {
+			t := time.NewTimer(q.timeout)
+			defer t.Stop()
+			timeout = t.C
+		}
+		select {
+		case <-room:
+		case <-timeout:
+			return p.abort(ErrSlowConsumer)
+		}
+		q.mu.Lock()
+	}
+}
+
+// ping queues a keepalive unless something else is already waiting to be
+// written, so pings never push out messages.
+func (p *HttpPusher) ping() {
+	q := p.queue
+	if q == nil {
+		_ = p.Push(pingMessage)
+		return
+	}
+
+	f, err := p.enc.Frame(pingMessage)
+	if err != nil {
+		return
+	}
+	q.mu.Lock()
+	if q.closed || q.size > 0 {
+		q.mu.Unlock()
+		return
+	}
+	q.items[q.head] = queueItem{frame: f}
+	q.size++
+	q.mu.Unlock()
+	q.wake()
+}
+
+func (q *pushQueue) wake() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (q *pushQueue) closeErr() error {
+	if q.err != nil {
+		return q.err
+	}
+	return http.ErrServerClosed
+}
+
+func (q *pushQueue) dropOldest() {
+	q.items[q.head] = queueItem{}
+	q.head = (q.head + 1) % len(q.items)
+	q.size--
+	q.dropped++
+}
+
+// coalesce replaces the newest queued item with key by f.
+func (q *pushQueue) coalesce(key string, f *Frame) bool {
+	if key == "" {
+		return false
+	}
+	for i := q.size - 1; i >= 0; i-- {
+		item := &q.items[(q.head+i)%len(q.items)]
+		if item.key == key {
+			item.frame = f
+			q.coalesced++
+			return true
+		}
+	}
+	return false
+}
+
+// take moves every queued frame to batch.
+func (q *pushQueue) take(batch []*Frame) []*Frame {
+	for i := range q.size {
+		item := &q.items[(q.head+i)%len(q.items)]
+		batch = append(batch, item.frame)
+		*item = queueItem{}
+	}
+	q.head, q.size = 0, 0
+	if q.room != nil {
+		close(q.room)
+		q.room = nil
+	}
+	return batch
+}
+
+func (q *pushQueue) discard() {
+	clear(q.items)
+	q.head, q.size = 0, 0
+}
+
+// closeLocked stops the queue taking messages and wakes everyone waiting
+// on it. q.mu must be held.
+func (q *pushQueue) closeLocked(err error) {
+	q.closed = true
+	q.err = err
+	if q.room != nil {
+		close(q.room)
+		q.room = nil
+	}
+	q.wake()
+}
+
+// abort closes the pusher with err for a client that cannot keep up,
+// discarding what is queued and cutting short a write in progress. It does
+// not wait for the writer, which releases the rest as it exits.
+func (p *HttpPusher) abort(err error) error {
+	q := p.queue
+	q.mu.Lock()
+	if q.closed {
+		err := q.closeErr()
+		q.mu.Unlock()
+		return err
+	}
+	q.closeLocked(err)
+	q.discard()
+	p.interrupt()
+	q.mu.Unlock()
+
+	p.dropped()
+	return err
+}
+
+// dropped marks the pusher closed after it gave up on its client, and
+// tells the Stream owning it, if any.
+func (p *HttpPusher) dropped() {
+	p.closed.Store(true)
+	if p.onAbort != nil {
+		p.onAbort()
+	}
+}
+
+// interrupt makes a write blocked on the connection fail, where the
+// ResponseWriter supports deadlines. q.mu must be held.
+func (p *HttpPusher) interrupt() {
+	if !p.queue.released {
+		_ = http.NewResponseController(p.w).SetWriteDeadline(time.Now())
+	}
+}
+
+// writeLoop writes queued frames, a batch and a flush at a time, until the
+// queue is closed and empty or a write fails. If the pusher gave up on the
+// client rather than being closed, the writer releases it on the way out.
+func (p *HttpPusher) writeLoop() {
+	q := p.queue
+	defer func() {
+		q.mu.Lock()
+		gaveUp := q.err != nil
+		q.mu.Unlock()
+		if gaveUp {
+			p.release()
+		}
+		close(q.done)
+	}()
+
+	var batch []*Frame
+	for {
+		q.mu.Lock()
+		for q.size == 0 && !q.closed {
+			q.mu.Unlock()
+			<-q.ready
+			q.mu.Lock()
+		}
+		if q.size == 0 {
+			q.mu.Unlock()
+			return
+		}
+		batch = q.take(batch[:0])
+		q.mu.Unlock()
+
+		err := p.writeFrames(batch)
+		clear(batch)
+
+		q.mu.Lock()
+		if err != nil {
+			failed := !q.closed
+			if failed {
+				q.closeLocked(err)
+			}
+			q.discard()
+			q.mu.Unlock()
+			if failed {
+				p.dropped()
+			}
+			return
+		}
+		q.sent += uint64(len(batch))
+		q.mu.Unlock()
+	}
+}
+
+func (p *HttpPusher) writeFrames(batch []*Frame) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	for _, f := range batch {
+		if err := p.enc.WriteFrame(f); err != nil {
+			return err
+		}
+	}
+	p.flusher.Flush()
+
+	if p.pingTimer != nil {
+		p.pingTimer.Reset(p.pingDuration)
+	}
+	return nil
+}
+
+// stopQueue closes the queue, waits for the writer to finish what is
+// queued, for at most the queue timeout if one is set, and then for it to
+// exit. After that the connection is left alone.
+func (p *HttpPusher) stopQueue() {
+	q := p.queue
+	q.mu.Lock()
+	if !q.closed {
+		q.closeLocked(nil)
+	}
+	q.mu.Unlock()
+
+	if q.timeout > 0 {
+		t := time.NewTimer(q.timeout)
+		select {
+		case <-q.done:
+		case <-t.C:
+			q.mu.Lock()
+			q.discard()
+			p.interrupt()
+			q.mu.Unlock()
+		}
+		t.Stop()
+	}
+	<-q.done
+
+	q.mu.Lock()
+	q.released = true
+	q.mu.Unlock()
+}
@@ -0,0 +1,319 @@
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedResponseWriter holds every write until its gate is opened.
+type gatedResponseWriter struct {
+	header  http.Header
+	gate    chan struct{}
+	writing chan struct{} // receives each time a write starts
+	mux     sync.Mutex
+	buf     bytes.Buffer
+}
+
+func newGatedResponseWriter() *gatedResponseWriter {
+	return &gatedResponseWriter{
+		header:  make(http.Header),
+		gate:    make(chan struct{}),
+		writing: make(chan struct{}, 64),
+	}
+}
+
+func (w *gatedResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *gatedResponseWriter) Write(b []byte) (int, error) {
+	w.writing <- struct{}{}
+	<-w.gate
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	return w.buf.Write(b)
+}
+
+func (w *gatedResponseWriter) WriteHeader(statusCode int) {}
+
+func (w *gatedResponseWriter) Flush() {}
+
+// data returns the data of every message written, in order.
+func (w *gatedResponseWriter) data(t *testing.T) []string {
+	t.Helper()
+
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	var out []string
+	dec := NewDecoder(bytes.NewReader(w.buf.Bytes()))
+	for {
+		var msg Message
+		if err := dec.Decode(&msg); err != nil {
+			return out
+		}
+		out = append(out, msg.Data)
+	}
+}
+
+func TestHttpPusherQueueOverflow(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		opts      []HttpPusherOption
+		events    []string // event names of the pushes after "a"
+		wantErr   error    // from the last push
+		wantData  []string
+		wantStats QueueStats
+	}{
+		{
+			name:      "drop oldest",
+			opts:      []HttpPusherOption{WithHttpPusherQueue(2, OverflowDropOldest)},
+			wantData:  []string{"a", "c", "d"},
+			wantStats: QueueStats{Cap: 2, Sent: 3, Dropped: 1},
+		},
+		{
+			name:      "drop newest",
+			opts:      []HttpPusherOption{WithHttpPusherQueue(2, OverflowDropNewest)},
+			wantData:  []string{"a", "b", "c"},
+			wantStats: QueueStats{Cap: 2, Sent: 3, Dropped: 1},
+		},
+		{
+			name:      "coalesce",
+			opts:      []HttpPusherOption{WithHttpPusherQueue(2, OverflowCoalesce)},
+			events:    []string{"price", "volume", "price"},
+			wantData:  []string{"a", "d", "c"},
+			wantStats: QueueStats{Cap: 2, Sent: 3, Coalesced: 1},
+		},
+		{
+			name:      "coalesce without a match",
+			opts:      []HttpPusherOption{WithHttpPusherQueue(2, OverflowCoalesce)},
+			events:    []string{"price", "volume", "trade"},
+			wantData:  []string{"a", "c", "d"},
+			wantStats: QueueStats{Cap: 2, Sent: 3, Dropped: 1},
+		},
+		{
+			name: "coalesce by custom key",
+			opts: []HttpPusherOption{
+				WithHttpPusherQueue(2, OverflowCoalesce),
+				WithHttpPusherCoalesceKey(func(msg *Message) string { return msg.Id }),
+			},
+			wantData:  []string{"a", "c", "d"},
+			wantStats: QueueStats{Cap: 2, Sent: 3, Dropped: 1},
+		},
+		{
+			name:      "disconnect",
+			opts:      []HttpPusherOption{WithHttpPusherQueue(2, OverflowDisconnect)},
+			wantErr:   ErrSlowConsumer,
+			wantData:  []string{"a"},
+			wantStats: QueueStats{Cap: 2, Sent: 1},
+		},
+		{
+			name: "block with timeout",
+			opts: []HttpPusherOption{
+				WithHttpPusherQueue(2, OverflowBlock),
+				WithHttpPusherQueueTimeout(20 * time.Millisecond),
+			},
+			wantErr:   ErrSlowConsumer,
+			wantData:  []string{"a"},
+			wantStats: QueueStats{Cap: 2, Sent: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			w := newGatedResponseWriter()
+			pusher, err := CreateHttpPusher(w, tt.opts...)
+			if err != nil {
+				t.Fatalf("CreateHttpPusher() error = %v", err)
+			}
+
+			// "a" is taken by the writer, which then waits on the gate, so
+			// the rest fill the queue.
+			if err := pusher.Push(&Message{Id: "a", Data: "a"}); err != nil {
+				t.Fatalf("Push(a) error = %v", err)
+			}
+			<-w.writing
+
+			events := tt.events
+			if events == nil {
+				events = []string{"", "", ""}
+			}
+			for i, event := range events {
+				data := string(rune('b' + i))
+				err := pusher.Push(&Message{Id: data, Event: event, Data: data})
+				if i < len(events)-1 && err != nil {
+					t.Fatalf("Push(%s) error = %v", data, err)
+				}
+				if i == len(events)-1 && !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Push(%s) error = %v, want %v", data, err, tt.wantErr)
+				}
+			}
+
+			// A pusher that gave up on its client is already closed.
+			wantClose := error(nil)
+			if tt.wantErr != nil {
+				wantClose = http.ErrServerClosed
+			}
+			close(w.gate)
+			if err := pusher.Close(); !errors.Is(err, wantClose) {
+				t.Fatalf("Close() error = %v, want %v", err, wantClose)
+			}
+
+			if got := w.data(t); !slices.Equal(got, tt.wantData) {
+				t.Fatalf("written data = %q, want %q", got, tt.wantData)
+			}
+			if got := pusher.QueueStats(); got != tt.wantStats {
+				t.Fatalf("QueueStats() = %+v, want %+v", got, tt.wantStats)
+			}
+			if tt.wantErr != nil {
+				if err := pusher.Push(&Message{Data: "e"}); !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Push() after overflow error = %v, want %v", err, tt.wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestHttpPusherQueueBlockWaitsForRoom(t *testing.T) {
+	t.Parallel()
+
+	w := newGatedResponseWriter()
+	pusher, err := CreateHttpPusher(w, WithHttpPusherQueue(1, OverflowBlock))
+	if err != nil {
+		t.Fatalf("CreateHttpPusher() error = %v", err)
+	}
+
+	if err := pusher.Push(&Message{Data: "a"}); err != nil {
+		t.Fatalf("Push(a) error = %v", err)
+	}
+	<-w.writing
+	if err := pusher.Push(&Message{Data: "b"}); err != nil {
+		t.Fatalf("Push(b) error = %v", err)
+	}
+
+	pushed := make(chan error, 1)
+	go func() {
+		pushed <- pusher.Push(&Message{Data: "c"})
+	}()
+	select {
+	case err := <-pushed:
+		t.Fatalf("Push(c) = %v before the queue had room", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(w.gate)
+	if err := <-pushed; err != nil {
+		t.Fatalf("Push(c) error = %v", err)
+	}
+	if err := pusher.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if got, want := w.data(t), []string{"a", "b", "c"}; !slices.Equal(got, want) {
+		t.Fatalf("written data = %q, want %q", got, want)
+	}
+}
+
+func TestHttpPusherQueueRejectsInvalidMessage(t *testing.T) {
+	t.Parallel()
+
+	pusher, err := CreateHttpPusher(&recordingResponseWriter{}, WithHttpPusherQueue(4, OverflowBlock))
+	if err != nil {
+		t.Fatalf("CreateHttpPusher() error = %v", err)
+	}
+	defer pusher.Close()
+
+	if err := pusher.Push(&Message{Event: "a\nb"}); !errors.Is(err, ErrInvalidField) {
+		t.Fatalf("Push() error = %v, want %v", err, ErrInvalidField)
+	}
+}
+
+func TestHttpPusherQueueCloseFlushes(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(Handler(func(ctx context.Context, stream *Stream) error {
+		for i := range 100 {
+			if err := stream.Push(&Message{Id: fmt.Sprint(i), Data: fmt.Sprint(i)}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, WithHandlerPusher(WithHttpPusherQueue(128, OverflowBlock))))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	defer resp.Body.Close()
+
+	dec := NewDecoder(resp.Body)
+	for i := range 100 {
+		var msg Message
+		if err := dec.Decode(&msg); err != nil {
+			t.Fatalf("Decode() #%d error = %v", i, err)
+		}
+		if msg.Data != fmt.Sprint(i) {
+			t.Fatalf("Decode() #%d data = %q, want %q", i, msg.Data, fmt.Sprint(i))
+		}
+	}
+}
+
+func TestHttpPusherQueueDisconnectsStalledClient(t *testing.T) {
+	t.Parallel()
+
+	pushErr := make(chan error, 1)
+	done := make(chan struct{})
+	server := httptest.NewServer(Handler(func(ctx context.Context, stream *Stream) error {
+		go func() {
+			big := &Message{Data: strings.Repeat("x", 64<<10)}
+			for {
+				if err := stream.Push(big); err != nil {
+					pushErr <- err
+					return
+				}
+			}
+		}()
+		// Giving up on the client must end the stream's context, or a
+		// handler waiting on it would keep the connection open.
+		<-ctx.Done()
+		close(done)
+		return nil
+	}, WithHandlerPusher(WithHttpPusherQueue(4, OverflowDisconnect))))
+	defer server.Close()
+
+	// A client that sends the request and then never reads, so the
+	// server's writes stall once the socket buffers fill up.
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\n\r\n", server.Listener.Addr())
+	if _, err := http.ReadResponse(bufio.NewReader(conn), nil); err != nil {
+		t.Fatalf("ReadResponse() error = %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("stream context not done after giving up on the stalled client")
+	}
+	if err := <-pushErr; !errors.Is(err, ErrSlowConsumer) {
+		t.Fatalf("Push() error = %v, want %v", err, ErrSlowConsumer)
+	}
+}
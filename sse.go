@@ -4,9 +4,14 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"mime"
 	"net/http"
+	"net/http/httptrace"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -21,27 +26,52 @@ const (
 	// beyond the final string conversion.
 	defaultReaderSize = 4096
 
-	// maxPushBufferRetain caps the scratch buffer capacity an HttpPusher
-	// keeps alive between pushes, so a single large message does not pin
-	// memory for the lifetime of the connection.
+	// maxPushBufferRetain caps the scratch buffer capacity an Encoder (and
+	// so an HttpPusher) keeps alive between writes, so a single large
+	// message does not pin memory for the lifetime of the connection.
 	maxPushBufferRetain = 64 << 10
+
+	// defaultMaxRetryAfter caps how long a Retry-After header can make an
+	// HttpReceiver wait unless WithHttpReceiverMaxRetryAfter says otherwise.
+	defaultMaxRetryAfter = 5 * time.Minute
+
+	// maxDecodeBufferRetain is the decoding counterpart of
+	// maxPushBufferRetain for a Decoder's scratch buffers.
+	maxDecodeBufferRetain = 64 << 10
 )
 
 type Message struct {
 	Id    string
 	Event string
 	Data  string
+
+	// Comment holds the text of comment lines (those starting with ':').
+	// Comments are not events; browsers never dispatch them.
+	Comment string
+
+	// Retry is the reconnection time sent with a retry field. Zero means
+	// the message did not carry one, or carried retry: 0. An encoded Retry
+	// under a millisecond is rounded up to one, the field's unit.
+	Retry time.Duration
 }
 
-// trimLineEnd strips a trailing LF or CRLF.
-func trimLineEnd(b []byte) []byte {
-	if n := len(b); n > 0 && b[n-1] == '\n' {
-		b = b[:n-1]
-		if n = len(b); n > 0 && b[n-1] == '\r' {
-			b = b[:n-1]
+// parseRetry parses the value of a retry field. Per the WHATWG spec the
+// value must consist of ASCII digits only; anything else is ignored.
+func parseRetry(value []byte) (time.Duration, bool) {
+	if len(value) == 0 {
+		return 0, false
+	}
+	var ms int64
+	for _, c := range value {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		ms = ms*10 + int64(c-'0')
+		if ms > math.MaxInt64/int64(time.Millisecond) {
+			return 0, false
 		}
 	}
-	return b
+	return time.Duration(ms) * time.Millisecond, true
 }
 
 // grow ensures dst has capacity for at least need bytes, at least doubling
@@ -62,100 +92,393 @@ func appendGrow(dst, src []byte) []byte {
 	return append(dst, src...)
 }
 
-// readLine returns the next line from br without its line ending. The
-// returned slice aliases br's internal buffer (or *spill for lines longer
-// than that buffer) and is only valid until the next call.
-func readLine(br *bufio.Reader, spill *[]byte) ([]byte, error) {
-	line, err := br.ReadSlice('\n')
-	if err == nil {
-		return trimLineEnd(line), nil
+// utf8BOM is stripped from the start of a stream, as the spec requires.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// lineReader splits a stream into lines terminated by CRLF, LF or a bare CR.
+// It only consumes from br what it returns, so no input is lost when the
+// caller keeps reading from br afterwards.
+type lineReader struct {
+	br      *bufio.Reader
+	spill   []byte // long-line overflow, allocated only when needed
+	started bool   // the leading BOM has been dealt with
+	skipLF  bool   // the last line ended in a CR whose LF may not have arrived yet
+	offset  int64  // bytes consumed from br
+	lines   int    // lines returned
+
+	maxLine  int  // longest line accepted, 0 for no limit
+	overflow bool // the rest of an oversized line is still to be skipped
+}
+
+func (lr *lineReader) reset(br *bufio.Reader) {
+	lr.br = br
+	lr.started = false
+	lr.skipLF = false
+	lr.offset = 0
+	lr.lines = 0
+	lr.overflow = false
+	if cap(lr.spill) > maxDecodeBufferRetain {
+		lr.spill = nil
 	}
+}
+
+func (lr *lineReader) discard(n int) {
+	_, _ = lr.br.Discard(n)
+	lr.offset += int64(n)
+}
 
-	if err != bufio.ErrBufferFull {
-		if err == io.EOF && len(line) > 0 {
-			return trimLineEnd(line), io.EOF
+// indexLineEnd returns the index of the first CR or LF in b, or -1. LF-only
+// input pays for a single extra scan of the line itself; short lines are
+// scanned inline since the call overhead of IndexByte dominates there.
+func indexLineEnd(b []byte) int {
+	i := bytes.IndexByte(b, '\n')
+	head := b
+	if i >= 0 {
+		head = b[:i]
+	}
+	if len(head) <= 64 {
+		for j, c := range head {
+			if c == '\r' {
+				return j
+			}
 		}
-		return nil, err
+	} else if j := bytes.IndexByte(head, '\r'); j >= 0 {
+		return j
 	}
+	return i
+}
 
-	buf := appendGrow((*spill)[:0], line)
+// skipBOM discards a UTF-8 BOM at the current position. It only waits for
+// more input when the bytes seen so far could still be a BOM.
+func (lr *lineReader) skipBOM() {
+	b, _ := lr.br.Peek(1)
+	if len(b) == 0 || b[0] != utf8BOM[0] {
+		return
+	}
+	if b, _ = lr.br.Peek(len(utf8BOM)); bytes.Equal(b, utf8BOM) {
+		lr.discard(len(utf8BOM))
+	}
+}
+
+// readLine returns the next line without its line ending. The returned
+// slice aliases br's internal buffer (or lr.spill for lines longer than that
+// buffer) and is only valid until the next call.
+func (lr *lineReader) readLine() ([]byte, error) {
+	if !lr.started {
+		lr.started = true
+		lr.skipBOM()
+	}
+	if lr.overflow {
+		if err := lr.skipLine(); err != nil {
+			return nil, err
+		}
+	}
+
+	spilled := false
+	scanned := 0
 	for {
-		line, err = br.ReadSlice('\n')
-		buf = appendGrow(buf, line)
-		if err != bufio.ErrBufferFull {
-			break
+		n := lr.br.Buffered()
+		if n <= scanned {
+			// Pull more input into the buffer. Peek keeps what is already
+			// buffered in place, so the common case stays copy-free.
+			if _, err := lr.br.Peek(n + 1); err != nil {
+				buf, _ := lr.br.Peek(n)
+				if err != io.EOF {
+					return nil, err
+				}
+				lr.discard(n)
+				if spilled {
+					lr.lines++
+					lr.spill = appendGrow(lr.spill, buf)
+					return lr.spill, io.EOF
+				}
+				if n > 0 {
+					lr.lines++
+					return buf, io.EOF
+				}
+				return nil, io.EOF
+			}
+			continue
+		}
+
+		buf, _ := lr.br.Peek(n)
+		if lr.skipLF {
+			lr.skipLF = false
+			if buf[0] == '\n' {
+				lr.discard(1)
+				continue
+			}
+		}
+
+		pending := 0
+		if spilled {
+			pending = len(lr.spill)
+		}
+
+		end := indexLineEnd(buf[scanned:])
+		if end < 0 {
+			if lr.maxLine > 0 && pending+n > lr.maxLine {
+				// Drop what has been read so far and skip the rest of the
+				// line on the next call, so the limit bounds memory even
+				// for a line that never ends.
+				lr.discard(n)
+				lr.overflow = true
+				return nil, errLineTooLong(lr.maxLine)
+			}
+			scanned = n
+			if n == lr.br.Size() {
+				if !spilled {
+					lr.spill = lr.spill[:0]
+					spilled = true
+				}
+				lr.spill = appendGrow(lr.spill, buf)
+				lr.discard(n)
+				scanned = 0
+			}
+			continue
+		}
+		end += scanned
+
+		line := buf[:end]
+		lr.discard(lr.lineEnd(buf, end))
+		lr.lines++
+
+		if lr.maxLine > 0 && pending+end > lr.maxLine {
+			return nil, errLineTooLong(lr.maxLine)
+		}
+		if spilled {
+			lr.spill = appendGrow(lr.spill, line)
+			return lr.spill, nil
 		}
+		return line, nil
 	}
-	*spill = buf
+}
 
-	if err != nil && err != io.EOF {
-		return nil, err
+// lineEnd returns the length of the line ending at buf[end], including the
+// LF of a CRLF. A CR at the end of buf may be followed by an LF that has not
+// arrived yet, which the next read skips.
+func (lr *lineReader) lineEnd(buf []byte, end int) int {
+	next := end + 1
+	if buf[end] == '\r' {
+		if next == len(buf) {
+			lr.skipLF = true
+		} else if buf[next] == '\n' {
+			next++
+		}
 	}
+	return next
+}
 
-	return trimLineEnd(buf), err
+// skipLine discards input up to and including the next line ending without
+// buffering it.
+func (lr *lineReader) skipLine() error {
+	for {
+		n := lr.br.Buffered()
+		if n == 0 {
+			if _, err := lr.br.Peek(1); err != nil {
+				return err
+			}
+			continue
+		}
+		buf, _ := lr.br.Peek(n)
+		end := indexLineEnd(buf)
+		if end < 0 {
+			lr.discard(n)
+			continue
+		}
+		lr.discard(lr.lineEnd(buf, end))
+		lr.lines++
+		lr.overflow = false
+		return nil
+	}
 }
 
-// appendDataLine accumulates one data (or comment) line into msg. The first
-// line is stored directly in msg.Data so single-line messages, by far the
-// common case, never touch the multi-line accumulator.
-func appendDataLine(msg *Message, dataBuf *[]byte, haveData *bool, value []byte) {
-	if !*haveData {
-		*haveData = true
-		msg.Data = string(value)
+// appendLine accumulates one data or comment line into *acc, to be turned
+// into a string once the message is complete. While *acc has no room yet,
+// as in a one-shot ReadMessage, the first line is stored directly in *dst
+// instead, so a single-line value costs one allocation either way.
+func appendLine(dst *string, acc *[]byte, have *bool, value []byte) {
+	buf := *acc
+	if !*have {
+		*have = true
+		if cap(buf) < len(value) {
+			*dst = string(value)
+			return
+		}
+		*acc = append(buf, value...)
 		return
 	}
 
-	buf := *dataBuf
-	if buf == nil {
-		buf = make([]byte, 0, max(2*(len(msg.Data)+len(value)+1), 64))
-		buf = append(buf, msg.Data...)
+	if len(buf) == 0 {
+		buf = grow(buf[:0], max(2*(len(*dst)+len(value)+1), 64))
+		buf = append(buf, *dst...)
 	}
 	buf = grow(buf, len(buf)+len(value)+1)
 	buf = append(buf, '\n')
 	buf = append(buf, value...)
-	*dataBuf = buf
+	*acc = buf
+}
+
+// Decoder reads messages from a stream. Unlike ReadMessage, it keeps its
+// read buffer and scratch space between calls, so decoding a steady stream
+// allocates nothing beyond the strings of each message.
+type Decoder struct {
+	lr         lineReader
+	own        *bufio.Reader // reader allocated by the decoder, reused by Reset
+	size       int
+	dataBuf    []byte // multi-line data accumulator
+	commentBuf []byte // multi-line comment accumulator
+	event      string // last event name, reused while it repeats
+	maxMessage int    // largest message accepted, 0 for no limit
+	discarding bool   // the rest of an oversized message is still to be skipped
+	hasRetry   bool   // the last decoded message carried a valid retry field
 }
 
-func ReadMessage(r io.Reader) (*Message, error) {
+// ErrMessageTooLarge is returned when a line or message exceeds the limits
+// set with WithDecoderMaxLineSize or WithDecoderMaxMessageSize.
+var ErrMessageTooLarge = errors.New("sse: message too large")
+
+func errLineTooLong(max int) error {
+	return fmt.Errorf("%w: line longer than %d bytes", ErrMessageTooLarge, max)
+}
+
+type DecoderOption func(*Decoder)
+
+// WithDecoderBufferSize sets the size of the read buffer. Lines shorter than
+// the buffer are parsed without copying. It has no effect when the decoder
+// reads from a *bufio.Reader, which is used as-is.
+func WithDecoderBufferSize(size int) DecoderOption {
+	return func(d *Decoder) {
+		d.size = size
+	}
+}
+
+// WithDecoderMaxLineSize limits the length of a single line, excluding its
+// line ending. A longer line is never buffered in full: Decode returns
+// ErrMessageTooLarge as soon as the limit is passed. Zero means no limit.
+func WithDecoderMaxLineSize(size int) DecoderOption {
+	return func(d *Decoder) {
+		d.lr.maxLine = size
+	}
+}
+
+// WithDecoderMaxMessageSize limits the total length of the lines making up a
+// message, excluding line endings. Zero means no limit.
+func WithDecoderMaxMessageSize(size int) DecoderOption {
+	return func(d *Decoder) {
+		d.maxMessage = size
+	}
+}
+
+func NewDecoder(r io.Reader, opts ...DecoderOption) *Decoder {
+	d := &Decoder{
+		size: defaultReaderSize,
+	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	d.Reset(r)
+	return d
+}
+
+// Reset discards any buffered input and parse state and makes d read from
+// r, keeping the buffers it has already allocated.
+func (d *Decoder) Reset(r io.Reader) {
 	br, ok := r.(*bufio.Reader)
 	if !ok {
-		br = bufio.NewReaderSize(r, defaultReaderSize)
+		if d.own == nil {
+			d.own = bufio.NewReaderSize(r, d.size)
+		} else {
+			d.own.Reset(r)
+		}
+		br = d.own
 	}
+	d.lr.reset(br)
+	d.discarding = false
+}
 
-	msg := &Message{}
-	var spill []byte   // long-line overflow, allocated only when needed
-	var dataBuf []byte // multi-line data accumulator, allocated only when needed
+// InputOffset returns the number of bytes consumed from the stream since
+// the decoder was created or last Reset.
+func (d *Decoder) InputOffset() int64 {
+	return d.lr.offset
+}
+
+// Line returns the number of lines consumed from the stream since the
+// decoder was created or last Reset, which is the line number of the last
+// line parsed.
+func (d *Decoder) Line() int {
+	return d.lr.lines
+}
+
+// Decode reads the next message into msg, overwriting all of its fields. It
+// returns io.EOF when the stream ends before another message starts.
+//
+// When a message exceeds the configured size limits Decode returns an error
+// wrapping ErrMessageTooLarge, and the next call skips the rest of that
+// message and decodes the one after it.
+func (d *Decoder) Decode(msg *Message) error {
+	*msg = Message{}
+	d.hasRetry = false
+	if d.discarding {
+		if err := d.skipMessage(); err != nil {
+			return err
+		}
+	}
+
+	dataBuf := d.dataBuf[:0]
+	commentBuf := d.commentBuf[:0]
 	haveMessage := false
 	haveData := false
+	haveComment := false
+	size := 0
+
+	defer func() {
+		// Keep the accumulators for the next call unless a single huge
+		// message grew them.
+		if cap(dataBuf) <= maxDecodeBufferRetain {
+			d.dataBuf = dataBuf[:0]
+		} else {
+			d.dataBuf = nil
+		}
+		if cap(commentBuf) <= maxDecodeBufferRetain {
+			d.commentBuf = commentBuf[:0]
+		} else {
+			d.commentBuf = nil
+		}
+	}()
 
 	for {
-		line, err := readLine(br, &spill)
+		line, err := d.lr.readLine()
 		if err != nil && err != io.EOF {
-			return nil, err
+			return d.fail(msg, err, true)
 		}
 
 		if len(line) == 0 {
 			if haveMessage {
-				if dataBuf != nil {
-					msg.Data = string(dataBuf)
-				}
-				return msg, nil
+				break
 			}
 
 			if err == io.EOF {
-				return nil, io.EOF
+				return io.EOF
 			}
 
 			continue
 		}
 
+		size += len(line)
+		if d.maxMessage > 0 && size > d.maxMessage {
+			return d.fail(msg, fmt.Errorf("%w: message longer than %d bytes", ErrMessageTooLarge, d.maxMessage), err != io.EOF)
+		}
+
 		if line[0] == ':' {
 			haveMessage = true
 			comment := line[1:]
 			if len(comment) > 0 && comment[0] == ' ' {
 				comment = comment[1:]
 			}
-			appendDataLine(msg, &dataBuf, &haveData, comment)
+			appendLine(&msg.Comment, &commentBuf, &haveComment, comment)
 		} else {
 			sep := bytes.IndexByte(line, ':')
 			field := line
@@ -171,7 +494,7 @@ func ReadMessage(r io.Reader) (*Message, error) {
 			switch string(field) {
 			case "data":
 				haveMessage = true
-				appendDataLine(msg, &dataBuf, &haveData, value)
+				appendLine(&msg.Data, &dataBuf, &haveData, value)
 			case "id":
 				haveMessage = true
 				if bytes.IndexByte(value, 0) < 0 {
@@ -179,76 +502,423 @@ func ReadMessage(r io.Reader) (*Message, error) {
 				}
 			case "event":
 				haveMessage = true
-				msg.Event = string(value)
+				if string(value) != d.event {
+					d.event = string(value)
+				}
+				msg.Event = d.event
+			case "retry":
+				if retry, ok := parseRetry(value); ok {
+					haveMessage = true
+					msg.Retry = retry
+					d.hasRetry = true
+				}
 			}
 		}
 
 		if err == io.EOF {
 			if haveMessage {
-				if dataBuf != nil {
-					msg.Data = string(dataBuf)
-				}
-				return msg, nil
+				break
 			}
-			return nil, io.EOF
+			return io.EOF
 		}
 	}
+
+	if len(dataBuf) > 0 {
+		msg.Data = string(dataBuf)
+	}
+	if len(commentBuf) > 0 {
+		msg.Comment = string(commentBuf)
+	}
+	return nil
+}
+
+// fail clears msg after a read error. An oversized message leaves the rest
+// of it to be skipped by the next Decode, unless the stream ended with it.
+func (d *Decoder) fail(msg *Message, err error, more bool) error {
+	if errors.Is(err, ErrMessageTooLarge) {
+		*msg = Message{}
+		d.discarding = more
+	}
+	return err
+}
+
+// skipMessage discards lines up to and including the next blank line.
+func (d *Decoder) skipMessage() error {
+	for {
+		line, err := d.lr.readLine()
+		if err != nil && !errors.Is(err, ErrMessageTooLarge) {
+			return err
+		}
+		if err == nil && len(line) == 0 {
+			d.discarding = false
+			return nil
+		}
+	}
+}
+
+// ReadMessage reads a single message from r. When r is a *bufio.Reader it is
+// read from directly and nothing past the message is consumed, so repeated
+// calls on the same reader see every message. Use a Decoder to read many
+// messages allocating only their strings.
+//
+// opts accept the same size limits as a Decoder. After an oversized message
+// r is left in the middle of it; use a Decoder to skip to the next one.
+func ReadMessage(r io.Reader, opts ...DecoderOption) (*Message, error) {
+	if len(opts) > 0 {
+		return readMessage(NewDecoder(r, opts...))
+	}
+
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReaderSize(r, defaultReaderSize)
+	}
+
+	d := Decoder{lr: lineReader{br: br}}
+	return readMessage(&d)
+}
+
+func readMessage(d *Decoder) (*Message, error) {
+	msg := &Message{}
+	if err := d.Decode(msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
 }
 
+// ErrInvalidField is returned when a message field cannot be written without
+// corrupting the stream, such as an id or event containing a line break.
+var ErrInvalidField = errors.New("sse: invalid field")
+
 func WriteMessage(w io.Writer, msg *Message, buf *bytes.Buffer) error {
+	if err := encodeMessage(buf, msg, nil, false); err != nil {
+		return err
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// validateMessage reports fields of msg that cannot be written as-is. A line
+// break in id or event would let the value inject extra fields or events,
+// and receivers ignore ids containing NUL.
+func validateMessage(msg *Message) error {
+	if hasLineBreak(msg.Id, true) {
+		return fmt.Errorf("%w: id contains a line break or NUL", ErrInvalidField)
+	}
+	if hasLineBreak(msg.Event, false) {
+		return fmt.Errorf("%w: event contains a line break", ErrInvalidField)
+	}
+	if msg.Retry < 0 {
+		return fmt.Errorf("%w: negative retry", ErrInvalidField)
+	}
+	return nil
+}
+
+// hasLineBreak reports whether s contains a CR or LF, or a NUL when nul is
+// set. Ids and event names are short, where a plain loop beats
+// strings.ContainsAny.
+func hasLineBreak(s string, nul bool) bool {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\r', '\n':
+			return true
+		case 0:
+			if nul {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Field is an extra field written after the standard ones. Receivers ignore
+// fields they do not know, so these are for custom clients.
+type Field struct {
+	Name  string
+	Value string
+}
+
+func validateFields(fields []Field) error {
+	for _, f := range fields {
+		if f.Name == "" || strings.ContainsAny(f.Name, ":\r\n") {
+			return fmt.Errorf("%w: field name %q", ErrInvalidField, f.Name)
+		}
+		if hasLineBreak(f.Value, false) {
+			return fmt.Errorf("%w: %s contains a line break", ErrInvalidField, f.Name)
+		}
+	}
+	return nil
+}
+
+// stripChars returns s with every byte in chars removed.
+func stripChars(s, chars string) string {
+	if !strings.ContainsAny(s, chars) {
+		return s
+	}
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(chars, r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// encodeMessage appends the wire form of msg and any extra fields to buf.
+// Invalid fields are rejected with ErrInvalidField before anything is
+// written, unless sanitize is set, in which case the offending characters
+// are dropped instead. Extra fields with an unusable name are always
+// rejected.
+func encodeMessage(buf *bytes.Buffer, msg *Message, fields []Field, sanitize bool) error {
+	id, event, retry := msg.Id, msg.Event, msg.Retry
+	if err := validateMessage(msg); err != nil {
+		if !sanitize {
+			return err
+		}
+		id = stripChars(id, "\r\n\x00")
+		event = stripChars(event, "\r\n")
+		retry = max(retry, 0)
+	}
+	if err := validateFields(fields); err != nil {
+		if !sanitize {
+			return err
+		}
+		clean := make([]Field, 0, len(fields))
+		for _, f := range fields {
+			if f.Name == "" || strings.ContainsAny(f.Name, ":\r\n") {
+				return err
+			}
+			clean = append(clean, Field{Name: f.Name, Value: stripChars(f.Value, "\r\n")})
+		}
+		fields = clean
+	}
+
 	// For large payloads, pre-size the buffer in one shot instead of paying
 	// repeated grow-and-copy steps. For small payloads the extra scan of
 	// msg.Data costs more than it saves, so skip it.
-	if len(msg.Data) >= 1024 {
-		size := 1 + len("id: \n") + len(msg.Id) + len("event: \n") + len(msg.Event) +
-			len(msg.Data) + (strings.Count(msg.Data, "\n")+1)*len("data: \n")
+	if len(msg.Data)+len(msg.Comment) >= 1024 {
+		size := 1 + len("id: \n") + len(id) + len("event: \n") + len(event) +
+			len("retry: \n") + 20 +
+			len(msg.Data) + (strings.Count(msg.Data, "\n")+1)*len("data: \n") +
+			len(msg.Comment) + (strings.Count(msg.Comment, "\n")+1)*len(": \n")
 		buf.Grow(size)
 	}
 
-	isComment := true
+	if msg.Comment != "" {
+		writeLines(buf, ": ", msg.Comment)
+	}
 
-	if msg.Id != "" {
+	if id != "" {
 		buf.WriteString("id: ")
-		buf.WriteString(msg.Id)
+		buf.WriteString(id)
 		buf.WriteByte('\n')
-		isComment = false
 	}
 
-	if msg.Event != "" {
+	if event != "" {
 		buf.WriteString("event: ")
-		buf.WriteString(msg.Event)
+		buf.WriteString(event)
+		buf.WriteByte('\n')
+	}
+
+	if retry > 0 {
+		// retry: 0 would tell the client to reconnect at once.
+		buf.WriteString("retry: ")
+		buf.Write(strconv.AppendInt(buf.AvailableBuffer(), max(retry.Milliseconds(), 1), 10))
+		buf.WriteByte('\n')
+	}
+
+	for _, f := range fields {
+		buf.WriteString(f.Name)
+		buf.WriteString(": ")
+		buf.WriteString(f.Value)
 		buf.WriteByte('\n')
-		isComment = false
 	}
 
 	if msg.Data != "" {
-		prefix := "data: "
-		if isComment {
-			prefix = ": "
-		}
-		data := msg.Data
-		for {
-			i := strings.IndexByte(data, '\n')
-			buf.WriteString(prefix)
-			if i < 0 {
-				buf.WriteString(data)
-				buf.WriteByte('\n')
-				break
-			}
-			buf.WriteString(data[:i])
+		writeLines(buf, "data: ", msg.Data)
+	}
+
+	buf.WriteByte('\n')
+	return nil
+}
+
+// indexLineBreak returns the index and width of the first CRLF, CR or LF in
+// s, or -1 if there is none.
+func indexLineBreak(s string) (int, int) {
+	i := strings.IndexByte(s, '\n')
+	head := s
+	if i >= 0 {
+		head = s[:i]
+	}
+	if j := strings.IndexByte(head, '\r'); j >= 0 {
+		if j+1 < len(s) && s[j+1] == '\n' {
+			return j, 2
+		}
+		return j, 1
+	}
+	return i, 1
+}
+
+// writeLines writes value as one prefixed line per embedded line break. CR,
+// LF and CRLF all count, as they do for receivers.
+func writeLines(buf *bytes.Buffer, prefix, value string) {
+	for {
+		i, width := indexLineBreak(value)
+		buf.WriteString(prefix)
+		if i < 0 {
+			buf.WriteString(value)
 			buf.WriteByte('\n')
-			data = data[i+1:]
+			return
 		}
+		buf.WriteString(value[:i])
+		buf.WriteByte('\n')
+		value = value[i+width:]
 	}
+}
 
-	buf.WriteByte('\n')
-	_, err := w.Write(buf.Bytes())
+// Frame is a message encoded once into its wire form, ready to be written
+// to any number of streams without encoding it again.
+type Frame struct {
+	b   []byte
+	msg Message // what b encodes, for coalescing keys
+}
+
+// NewFrame encodes msg and any extra fields into a Frame, rejecting invalid
+// fields with ErrInvalidField.
+func NewFrame(msg *Message, fields ...Field) (*Frame, error) {
+	return NewEncoder(io.Discard).Frame(msg, fields...)
+}
+
+// Bytes returns the wire form of the frame. It must not be modified.
+func (f *Frame) Bytes() []byte {
+	return f.b
+}
+
+// Encoder writes messages to a stream. By default each message is written
+// with a single Write call as soon as it is encoded; WithEncoderBuffer
+// batches messages until Flush.
+type Encoder struct {
+	w        io.Writer
+	buf      bytes.Buffer
+	size     int // buffered mode: write out once this many bytes are pending
+	sanitize bool
+}
+
+type EncoderOption func(*Encoder)
+
+// WithEncoderBuffer makes the encoder hold encoded messages until Flush is
+// called or more than size bytes are pending.
+func WithEncoderBuffer(size int) EncoderOption {
+	return func(e *Encoder) {
+		e.size = size
+	}
+}
+
+// WithEncoderSanitize makes the encoder drop line breaks (and NUL in ids)
+// from id, event and extra field values instead of rejecting the message
+// with ErrInvalidField.
+func WithEncoderSanitize() EncoderOption {
+	return func(e *Encoder) {
+		e.sanitize = true
+	}
+}
+
+func NewEncoder(w io.Writer, opts ...EncoderOption) *Encoder {
+	e := &Encoder{
+		w: w,
+	}
+
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	return e
+}
+
+// Reset discards any pending output and makes e write to w.
+func (e *Encoder) Reset(w io.Writer) {
+	e.w = w
+	e.buf.Reset()
+}
+
+// Buffered returns the number of encoded bytes not yet written.
+func (e *Encoder) Buffered() int {
+	return e.buf.Len()
+}
+
+// Encode writes msg followed by any extra fields as a single event.
+func (e *Encoder) Encode(msg *Message, fields ...Field) error {
+	if e.size <= 0 {
+		e.buf.Reset()
+	}
+	if err := encodeMessage(&e.buf, msg, fields, e.sanitize); err != nil {
+		return err
+	}
+	return e.writeOut()
+}
+
+// WriteComment writes a comment block, such as a keepalive ping.
+func (e *Encoder) WriteComment(comment string) error {
+	return e.Encode(&Message{Comment: comment})
+}
+
+// Frame encodes msg and any extra fields into a Frame without writing it,
+// using the encoder's settings.
+func (e *Encoder) Frame(msg *Message, fields ...Field) (*Frame, error) {
+	var buf bytes.Buffer
+	if err := encodeMessage(&buf, msg, fields, e.sanitize); err != nil {
+		return nil, err
+	}
+	return &Frame{b: buf.Bytes(), msg: *msg}, nil
+}
+
+// WriteFrame writes a previously encoded frame.
+func (e *Encoder) WriteFrame(f *Frame) error {
+	if e.size <= 0 {
+		_, err := e.w.Write(f.b)
+		return err
+	}
+	e.buf.Write(f.b)
+	return e.writeOut()
+}
+
+// writeOut writes the pending output unless buffering allows holding it.
+func (e *Encoder) writeOut() error {
+	if e.size > 0 && e.buf.Len() <= e.size {
+		return nil
+	}
+	return e.flushBuffer()
+}
+
+func (e *Encoder) flushBuffer() error {
+	var err error
+	if e.buf.Len() > 0 {
+		_, err = e.w.Write(e.buf.Bytes())
+	}
+	e.buf.Reset()
+	if e.buf.Cap() > maxPushBufferRetain {
+		e.buf = bytes.Buffer{}
+	}
 	return err
 }
 
-func NewComment(data string) *Message {
+// Flush writes any pending output and then flushes the underlying writer
+// when it is an http.Flusher or has a Flush() error method, such as a
+// *bufio.Writer.
+func (e *Encoder) Flush() error {
+	if err := e.flushBuffer(); err != nil {
+		return err
+	}
+	switch f := e.w.(type) {
+	case http.Flusher:
+		f.Flush()
+	case interface{ Flush() error }:
+		return f.Flush()
+	}
+	return nil
+}
+
+func NewComment(comment string) *Message {
 	return &Message{
-		Data: data,
+		Comment: comment,
 	}
 }
 
@@ -267,12 +937,35 @@ type HttpPusher struct {
 	pingTimer    *time.Timer
 	closed       atomic.Bool
 	mux          sync.Mutex
-	buffer       bytes.Buffer
+	enc          *Encoder
+	queue        *pushQueue // nil unless WithHttpPusherQueue
+	releaseOnce  sync.Once
+	onAbort      func() // called when the pusher gives up on the client
 }
 
 var _ Pusher = (*HttpPusher)(nil)
 
 func (p *HttpPusher) Push(msg *Message) error {
+	if p.queue != nil {
+		f, err := p.enc.Frame(msg)
+		if err != nil {
+			return err
+		}
+		return p.enqueue(f)
+	}
+	return p.push(msg, nil)
+}
+
+// PushFrame writes a frame encoded earlier, typically one shared by many
+// pushers so the message is encoded only once.
+func (p *HttpPusher) PushFrame(f *Frame) error {
+	if p.queue != nil {
+		return p.enqueue(f)
+	}
+	return p.push(nil, f)
+}
+
+func (p *HttpPusher) push(msg *Message, f *Frame) error {
 	if p.closed.Load() {
 		return http.ErrServerClosed
 	}
@@ -284,10 +977,11 @@ func (p *HttpPusher) Push(msg *Message) error {
 		return http.ErrServerClosed
 	}
 
-	p.buffer.Reset()
-	err := WriteMessage(p.w, msg, &p.buffer)
-	if p.buffer.Cap() > maxPushBufferRetain {
-		p.buffer = bytes.Buffer{}
+	var err error
+	if f != nil {
+		err = p.enc.WriteFrame(f)
+	} else {
+		err = p.enc.Encode(msg)
 	}
 	if err != nil {
 		return err
@@ -304,26 +998,41 @@ func (p *HttpPusher) Push(msg *Message) error {
 	return nil
 }
 
+// Close stops the pusher. With a send queue, it first waits for the queued
+// messages to be written; see WithHttpPusherQueueTimeout.
 func (p *HttpPusher) Close() error {
-	if p.closed.Swap(true) {
-		return http.ErrServerClosed
-	}
+	closed := p.closed.Swap(true)
 
-	p.mux.Lock()
-	if p.pingTimer != nil {
-		p.pingTimer.Stop()
-		p.pingTimer = nil
+	// Even after the pusher gave up on a slow client, its writer must be
+	// done with the connection before Close returns.
+	if p.queue != nil {
+		p.stopQueue()
 	}
-	closer := p.closer
-	p.mux.Unlock()
-
-	if closer != nil {
-		_ = closer.Close()
+	if closed {
+		return http.ErrServerClosed
 	}
 
+	p.release()
 	return nil
 }
 
+// release stops the keepalive and closes the underlying writer, once.
+func (p *HttpPusher) release() {
+	p.releaseOnce.Do(func() {
+		p.mux.Lock()
+		if p.pingTimer != nil {
+			p.pingTimer.Stop()
+			p.pingTimer = nil
+		}
+		closer := p.closer
+		p.mux.Unlock()
+
+		if closer != nil {
+			_ = closer.Close()
+		}
+	})
+}
+
 type HttpPusherOption func(*HttpPusher)
 
 func WithHttpPusherHeader(key, value string) HttpPusherOption {
@@ -338,6 +1047,15 @@ func WithHttpPusherPingDuration(d time.Duration) HttpPusherOption {
 	}
 }
 
+// WithHttpPusherSanitize makes Push drop line breaks (and NUL in ids) from
+// id and event values instead of rejecting the message with ErrInvalidField.
+// Use it when those values may come from untrusted input.
+func WithHttpPusherSanitize() HttpPusherOption {
+	return func(p *HttpPusher) {
+		p.enc.sanitize = true
+	}
+}
+
 func CreateHttpPusher(w http.ResponseWriter, opts ...HttpPusherOption) (*HttpPusher, error) {
 	out, ok := w.(http.Flusher)
 	if !ok {
@@ -347,6 +1065,7 @@ func CreateHttpPusher(w http.ResponseWriter, opts ...HttpPusherOption) (*HttpPus
 	pusher := &HttpPusher{
 		w:       w,
 		flusher: out,
+		enc:     NewEncoder(w),
 	}
 	if closer, ok := w.(io.Closer); ok {
 		pusher.closer = closer
@@ -361,82 +1080,338 @@ func CreateHttpPusher(w http.ResponseWriter, opts ...HttpPusherOption) (*HttpPus
 
 	out.Flush()
 
+	if pusher.queue != nil {
+		pusher.startQueue()
+	}
 	if pusher.pingDuration > 0 {
-		pusher.pingTimer = time.AfterFunc(pusher.pingDuration, func() {
-			_ = pusher.Push(pingMessage)
-		})
+		pusher.pingTimer = time.AfterFunc(pusher.pingDuration, pusher.ping)
 	}
 
 	return pusher, nil
 }
 
+// maxStatusErrorBody bounds how much of an error response body is kept in
+// a StatusError.
+const maxStatusErrorBody = 512
+
+// ErrContentType is returned when a server answers with a Content-Type other
+// than text/event-stream, such as an HTML error page from a proxy.
+var ErrContentType = errors.New("sse: response is not text/event-stream")
+
+// StatusError is returned when a server answers a connection attempt with a
+// status other than 200 OK. 408, 429 and 5xx responses are retried;
+// anything else, including 204 No Content, ends the stream for good.
+type StatusError struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte // at most the first 512 bytes of the response body
+
+	// RetryAfter is the delay requested by a Retry-After header on a 429 or
+	// 503 response, or zero.
+	RetryAfter time.Duration
+}
+
+// parseRetryAfter parses a Retry-After value, either delay-seconds or an
+// HTTP-date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if secs <= 0 {
+			return 0
+		}
+		if secs > int64(maxDuration/time.Second) {
+			return maxDuration
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(t.Sub(now), 0)
+	}
+	return 0
+}
+
+func (e *StatusError) Error() string {
+	msg := fmt.Sprintf("sse: unexpected status code: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if body := strings.TrimSpace(string(e.Body)); body != "" {
+		msg += ": " + body
+	}
+	return msg
+}
+
+// checkResponse reports why resp cannot be read as an event stream.
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxStatusErrorBody))
+		statusErr := &StatusError{
+			StatusCode: resp.StatusCode,
+			Header:     resp.Header,
+			Body:       body,
+		}
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+			statusErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		}
+		return statusErr
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != "text/event-stream" {
+		return fmt.Errorf("%w: got %q", ErrContentType, contentType)
+	}
+
+	return nil
+}
+
+// isRetryable reports whether a failed connection attempt may be retried.
+func isRetryable(err error) bool {
+	if errors.Is(err, ErrContentType) {
+		return false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		code := statusErr.StatusCode
+		return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+	}
+
+	return true
+}
+
 type Receiver interface {
 	Receive() (*Message, error)
+	ReceiveContext(ctx context.Context) (*Message, error)
 	Close() error
 }
 
 type HttpReceiver struct {
-	client     *http.Client
-	req        *http.Request
-	retryMax   int
-	retryDelay time.Duration
-	respHeader func(header http.Header)
+	client        *http.Client
+	req           *http.Request
+	retryMax      int
+	retryForever  bool
+	retryDelay    time.Duration
+	backoff       Backoff
+	healthyAfter  time.Duration
+	maxRetryAfter time.Duration
+	getBody       func() (io.ReadCloser, error)
+	beforeRequest func(req *http.Request) error
+	refreshAuth   func(ctx context.Context) error
+	nonResumable  bool
+	maxLine       int
+	maxMessage    int
+	reconnectBig  bool // reconnect instead of skipping oversized messages
+	idleTimeout   time.Duration
+	bufferPolicy  BufferPolicy
+	respHeader    func(header http.Header)
+	onConnecting  func(attempt int)
+	onOpen        func(resp *http.Response)
+	onRetry       func(attempt int, delay time.Duration, err error)
+	onDisconnect  func(cause error)
+	onClosed      func(err error)
+	closedOnce    sync.Once // guards onClosed
 
 	ctx    context.Context
 	cancel context.CancelFunc
 
 	lastEventID string
+	serverRetry time.Duration // latest retry value sent by the server
+	hasRetry    bool          // the server has sent a retry value
 	closed      atomic.Bool
 	mux         sync.Mutex
-	recvMux     sync.Mutex
 	body        io.ReadCloser
-	reader      *bufio.Reader // reused across reconnects
+	decoder     *Decoder // reused across reconnects
+
+	// attempt counts connection attempts since the last healthy connection
+	// and delays the backoff delays taken meanwhile, one more than attempt
+	// when the outage began with a healthy stream dropping. connectedAt is
+	// when the current connection opened and dropped is why it was
+	// dropped, if it was, until the next connect. They are only touched by
+	// connect and receive, which never run concurrently.
+	attempt     int
+	delays      int
+	connectedAt time.Time
+	dropped     error
+	failErr     error // set once the server has failed the stream for good
+
+	// recvSem serializes receives. It is a channel rather than a mutex so
+	// that ReceiveContext can give up waiting for it.
+	recvSem chan struct{}
+	// results carries the outcome of a receive that outlived the
+	// ReceiveContext call which started it; inFlight is set while one is
+	// running and pending is the message it decodes into. All three are
+	// guarded by recvSem.
+	results  chan recvResult
+	inFlight bool
+	pending  Message
+}
+
+type recvResult struct {
+	msg *Message
+	err error
 }
 
 var _ Receiver = (*HttpReceiver)(nil)
 
 func (r *HttpReceiver) Receive() (*Message, error) {
-	r.recvMux.Lock()
-	defer r.recvMux.Unlock()
+	return r.ReceiveContext(context.Background())
+}
 
+// ReceiveContext is like Receive but gives up when ctx is done, returning
+// ctx.Err(). The connection and Last-Event-ID state are left intact: a
+// message that arrives after ctx is done is returned by the next call.
+func (r *HttpReceiver) ReceiveContext(ctx context.Context) (*Message, error) {
+	msg := &Message{}
+	if err := r.ReceiveInto(ctx, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// ReceiveInto is like ReceiveContext but decodes into msg, overwriting all
+// of its fields. When ctx can never be done, such as context.Background(),
+// a loop reusing one Message allocates nothing per message beyond the
+// strings the message holds. Otherwise each call also reads on a new
+// goroutine, which costs one small allocation.
+func (r *HttpReceiver) ReceiveInto(ctx context.Context, msg *Message) error {
+	select {
+	case r.recvSem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-r.recvSem }()
+
+	if !r.inFlight {
+		if ctx.Done() == nil {
+			return r.receive(msg)
+		}
+		// Reading from the body cannot be interrupted without closing the
+		// connection, so read on a goroutine and let the caller walk away.
+		r.inFlight = true
+		go func() {
+			err := r.receive(&r.pending)
+			r.results <- recvResult{msg: &r.pending, err: err}
+		}()
+	}
+
+	select {
+	case res := <-r.results:
+		r.inFlight = false
+		*msg = *res.msg
+		*res.msg = Message{}
+		return res.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *HttpReceiver) receive(msg *Message) error {
 	for {
 		if r.closed.Load() {
-			return nil, http.ErrServerClosed
+			return http.ErrServerClosed
 		}
 
-		reader, err := r.getReader()
+		decoder, err := r.getDecoder()
 		if err != nil {
 			if r.closed.Load() {
-				return nil, http.ErrServerClosed
+				return http.ErrServerClosed
 			}
-			return nil, err
+			return err
 		}
 
-		msg, err := ReadMessage(reader)
+		err = decoder.Decode(msg)
 		if err == nil {
-			if msg != nil && msg.Id != "" {
+			if msg.Id != "" || decoder.hasRetry {
 				r.mux.Lock()
-				r.lastEventID = msg.Id
+				if msg.Id != "" {
+					r.lastEventID = msg.Id
+				}
+				if decoder.hasRetry {
+					r.serverRetry = msg.Retry
+					r.hasRetry = true
+				}
 				r.mux.Unlock()
 			}
-			return msg, nil
+			// Comments (such as keepalive pings) and blocks carrying only a
+			// retry field are not events.
+			if msg.Id == "" && msg.Event == "" && msg.Data == "" {
+				continue
+			}
+			return nil
 		}
 
 		if r.closed.Load() {
-			return nil, http.ErrServerClosed
+			return http.ErrServerClosed
+		}
+
+		if errors.Is(err, ErrMessageTooLarge) {
+			// The decoder skips the rest of the message on the next call
+			// unless the stream is to be dropped instead.
+			if r.reconnectBig {
+				r.closeBody()
+				r.disconnected(err)
+				if r.nonResumable {
+					r.fail(err)
+				} else {
+					r.streamEnded()
+					r.dropped = err
+				}
+			}
+			return err
 		}
 
 		r.closeBody()
+		r.disconnected(err)
 
-		if err := r.connect(); err != nil {
+		if r.nonResumable {
+			r.fail(err)
+			return err
+		}
+
+		r.streamEnded()
+
+		if err := r.connect(err); err != nil {
 			if r.closed.Load() {
-				return nil, http.ErrServerClosed
+				return http.ErrServerClosed
 			}
-			return nil, err
+			return err
 		}
 	}
 }
 
+// disconnected reports the end of a connection to the OnDisconnect hook.
+func (r *HttpReceiver) disconnected(cause error) {
+	if r.onDisconnect != nil {
+		r.onDisconnect(cause)
+	}
+}
+
+// fail ends the stream for good: every later Receive returns err.
+func (r *HttpReceiver) fail(err error) {
+	r.failErr = err
+	r.notifyClosed(err)
+}
+
+// notifyClosed calls the OnClosed hook the first time the receiver stops,
+// whether it was closed or failed.
+func (r *HttpReceiver) notifyClosed(err error) {
+	r.closedOnce.Do(func() {
+		if r.onClosed != nil {
+			r.onClosed(err)
+		}
+	})
+}
+
+// streamEnded starts counting attempts afresh if the connection that just
+// ended stayed up for at least healthyAfter. A connection that drops sooner
+// counts as a failed attempt, so a server that accepts and immediately
+// drops connections cannot keep the receiver reconnecting at full speed.
+func (r *HttpReceiver) streamEnded() {
+	if time.Since(r.connectedAt) >= r.healthyAfter {
+		r.attempt = 0
+		r.backoff.Reset()
+	}
+}
+
 func (r *HttpReceiver) Close() error {
 	if r.closed.Swap(true) {
 		return http.ErrServerClosed
@@ -444,22 +1419,62 @@ func (r *HttpReceiver) Close() error {
 
 	r.cancel()
 	r.closeBody()
+	r.notifyClosed(nil)
 
 	return nil
 }
 
-func (r *HttpReceiver) connect() error {
+// connect opens a new connection, retrying according to the retry policy.
+// cause is why the previous connection ended, if there was one.
+func (r *HttpReceiver) connect(cause error) error {
+	if r.failErr != nil {
+		return r.failErr
+	}
+
 	attempts := r.retryMax
 	if attempts <= 0 {
 		attempts = 1
 	}
 
-	var lastErr error
-	for attempt := 0; attempt < attempts; attempt++ {
+	lastErr := cause
+	refreshed := false // credentials refreshed during this call
+	retryNow := false
+
+	if r.attempt == 0 {
+		r.delays = 0
+		// Like browsers, wait the reconnection time even after a healthy
+		// stream drops, so a server can slow clients down with retry: and
+		// clients dropped together by a restart do not all come back at
+		// once.
+		if cause != nil && !r.waitRetry(cause) {
+			return http.ErrServerClosed
+		}
+	}
+
+	for {
 		if r.closed.Load() {
 			return http.ErrServerClosed
 		}
 
+		if r.attempt > 0 && !retryNow {
+			if !r.retryForever && r.attempt >= attempts {
+				failed := r.attempt
+				r.attempt = 0
+				if lastErr == nil {
+					lastErr = errors.New("connection was not healthy")
+				}
+				return fmt.Errorf("failed to connect after %d attempts: %w", failed, lastErr)
+			}
+			if !r.waitRetry(lastErr) {
+				return http.ErrServerClosed
+			}
+		}
+		retryNow = false
+		r.attempt++
+		if r.onConnecting != nil {
+			r.onConnecting(r.attempt)
+		}
+
 		req := r.req.Clone(r.ctx)
 
 		r.mux.Lock()
@@ -469,21 +1484,69 @@ func (r *HttpReceiver) connect() error {
 			req.Header.Set("Last-Event-ID", lastEventID)
 		}
 
+		if r.beforeRequest != nil {
+			if err := r.beforeRequest(req); err != nil {
+				lastErr = err
+				continue
+			}
+		}
+
+		if r.getBody != nil {
+			body, err := r.getBody()
+			if err != nil {
+				r.attempt = 0
+				return err
+			}
+			req.Body = body
+			req.GetBody = r.getBody
+		}
+
+		var sent atomic.Bool
+		if r.nonResumable {
+			req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+				WroteHeaders: func() { sent.Store(true) },
+			}))
+		}
+
 		resp, err := r.client.Do(req)
 		if err != nil {
-			lastErr = err
-			if !r.waitRetry(attempt, attempts) {
-				return http.ErrServerClosed
+			if sent.Load() {
+				// The server may have acted on the request already, so
+				// sending it again could, say, start a second job.
+				err = fmt.Errorf("sse: request failed after it was sent: %w", err)
+				r.attempt = 0
+				r.fail(err)
+				return err
 			}
+			lastErr = err
 			continue
 		}
 
-		if resp.StatusCode != http.StatusOK {
-			lastErr = fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		if err := checkResponse(resp); err != nil {
 			resp.Body.Close()
-			if !r.waitRetry(attempt, attempts) {
-				return http.ErrServerClosed
+
+			// Credentials may simply have expired: refresh them once and
+			// try again straight away, without counting an attempt.
+			var statusErr *StatusError
+			if r.refreshAuth != nil && !refreshed && errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusUnauthorized {
+				refreshed = true
+				refreshErr := r.refreshAuth(r.ctx)
+				if refreshErr == nil {
+					r.attempt--
+					retryNow = true
+					continue
+				}
+				err = fmt.Errorf("%w (refreshing credentials failed: %w)", err, refreshErr)
+			}
+
+			if !isRetryable(err) {
+				// Per the spec a failed connection is not retried, and
+				// 204 No Content is the server asking clients to stop.
+				r.attempt = 0
+				r.fail(err)
+				return err
 			}
+			lastErr = err
 			continue
 		}
 
@@ -500,29 +1563,69 @@ func (r *HttpReceiver) connect() error {
 		if r.body != nil {
 			_ = r.body.Close()
 		}
-		r.body = resp.Body
-		if r.reader == nil {
-			r.reader = bufio.NewReaderSize(resp.Body, defaultReaderSize)
+		body := resp.Body
+		if r.idleTimeout > 0 {
+			body = newIdleBody(body, r.idleTimeout)
+		}
+		r.body = body
+		if r.decoder == nil {
+			r.decoder = NewDecoder(body,
+				WithDecoderMaxLineSize(r.maxLine),
+				WithDecoderMaxMessageSize(r.maxMessage),
+			)
 		} else {
-			r.reader.Reset(resp.Body)
+			r.decoder.Reset(body)
 		}
 		r.mux.Unlock()
+		r.connectedAt = time.Now()
+		if r.onOpen != nil {
+			r.onOpen(resp)
+		}
 		return nil
 	}
+}
+
+// nextDelay returns the backoff delay before the given attempt. A server
+// that sent retry: 0 asked for clients to reconnect at once, which a Backoff
+// cannot tell from no retry field at all, so it is honored here.
+func (r *HttpReceiver) nextDelay(attempt int) time.Duration {
+	r.mux.Lock()
+	retry, hasRetry := r.serverRetry, r.hasRetry
+	r.mux.Unlock()
 
-	if lastErr == nil {
-		lastErr = fmt.Errorf("failed to connect after %d attempts", attempts)
+	if hasRetry && retry == 0 {
+		return 0
+	}
+	return r.backoff.Next(attempt, retry)
+}
+
+// waitRetry sleeps before the next attempt, reporting false if the receiver
+// was closed meanwhile. err is why the last attempt failed.
+func (r *HttpReceiver) waitRetry(err error) bool {
+	r.delays++
+	delay := r.nextDelay(r.delays)
+
+	// A server shedding load knows better than our schedule when to come
+	// back, within reason.
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 && r.maxRetryAfter > 0 {
+		delay = min(statusErr.RetryAfter, r.maxRetryAfter)
 	}
 
-	return fmt.Errorf("failed to connect after %d attempts: %w", attempts, lastErr)
+	if r.onRetry != nil {
+		r.onRetry(r.attempt, delay, err)
+	}
+	return r.sleep(delay)
 }
 
-func (r *HttpReceiver) waitRetry(attempt, attempts int) bool {
-	if attempt >= attempts-1 || r.retryDelay <= 0 {
+// sleep waits for delay, reporting false if the receiver was closed
+// meanwhile.
+func (r *HttpReceiver) sleep(delay time.Duration) bool {
+	if delay <= 0 {
 		return true
 	}
 
-	timer := time.NewTimer(r.retryDelay)
+	timer := time.NewTimer(delay)
 	defer timer.Stop()
 
 	select {
@@ -544,27 +1647,74 @@ func (r *HttpReceiver) closeBody() {
 	}
 }
 
-func (r *HttpReceiver) getReader() (*bufio.Reader, error) {
+// ErrIdleTimeout is the cause reported when a connection is dropped for
+// receiving nothing within the time set by WithHttpReceiverIdleTimeout.
+var ErrIdleTimeout = errors.New("sse: connection idle")
+
+// idleBody closes a response body that blocks a read for longer than
+// timeout. The timer only runs while a read is waiting for the network, so
+// a consumer that is slow to call Receive does not look like a dead
+// connection.
+type idleBody struct {
+	rc      io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	fired   atomic.Bool
+}
+
+func newIdleBody(rc io.ReadCloser, timeout time.Duration) *idleBody {
+	b := &idleBody{rc: rc, timeout: timeout}
+	b.timer = time.AfterFunc(timeout, b.expire)
+	b.timer.Stop()
+	return b
+}
+
+func (b *idleBody) expire() {
+	if !b.fired.Swap(true) {
+		_ = b.rc.Close()
+	}
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	if b.fired.Load() {
+		return 0, ErrIdleTimeout
+	}
+	b.timer.Reset(b.timeout)
+	n, err := b.rc.Read(p)
+	if !b.timer.Stop() && b.fired.Load() {
+		return n, ErrIdleTimeout
+	}
+	return n, err
+}
+
+func (b *idleBody) Close() error {
+	b.timer.Stop()
+	return b.rc.Close()
+}
+
+func (r *HttpReceiver) getDecoder() (*Decoder, error) {
 	r.mux.Lock()
-	reader, connected := r.reader, r.body != nil
+	decoder, connected := r.decoder, r.body != nil
 	r.mux.Unlock()
 
 	if connected {
-		return reader, nil
+		return decoder, nil
 	}
 
-	if err := r.connect(); err != nil {
+	cause := r.dropped
+	r.dropped = nil
+	if err := r.connect(cause); err != nil {
 		return nil, err
 	}
 
 	r.mux.Lock()
-	reader, connected = r.reader, r.body != nil
+	decoder, connected = r.decoder, r.body != nil
 	r.mux.Unlock()
 	if !connected {
 		return nil, io.EOF
 	}
 
-	return reader, nil
+	return decoder, nil
 }
 
 type HttpReceiverOption func(*HttpReceiver)
@@ -575,6 +1725,10 @@ func WithHttpReceiverClient(client *http.Client) HttpReceiverOption {
 	}
 }
 
+// WithHttpReceiverRetry sets the number of connection attempts made each
+// time the stream breaks, waiting a constant delay between them. Unless
+// WithHttpReceiverBackoff is also given, this is shorthand for
+// NewConstantBackoff(delay).
 func WithHttpReceiverRetry(max int, delay time.Duration) HttpReceiverOption {
 	return func(r *HttpReceiver) {
 		r.retryMax = max
@@ -582,6 +1736,197 @@ func WithHttpReceiverRetry(max int, delay time.Duration) HttpReceiverOption {
 	}
 }
 
+// WithHttpReceiverBackoff sets the policy deciding how long to wait between
+// connection attempts, replacing the constant delay of
+// WithHttpReceiverRetry. The number of attempts is still set by
+// WithHttpReceiverRetry.
+func WithHttpReceiverBackoff(backoff Backoff) HttpReceiverOption {
+	return func(r *HttpReceiver) {
+		r.backoff = backoff
+	}
+}
+
+// WithHttpReceiverHeader sets a header sent with every connection attempt.
+func WithHttpReceiverHeader(key, value string) HttpReceiverOption {
+	return func(r *HttpReceiver) {
+		r.req.Header.Set(key, value)
+	}
+}
+
+// WithHttpReceiverBeforeRequest sets a callback that may modify the request
+// before every connection attempt, for example to set a bearer token that
+// expires. An error fails the attempt, which is then retried like any other.
+func WithHttpReceiverBeforeRequest(beforeRequest func(req *http.Request) error) HttpReceiverOption {
+	return func(r *HttpReceiver) {
+		r.beforeRequest = beforeRequest
+	}
+}
+
+// WithHttpReceiverRefreshAuth sets a callback invoked when the server
+// answers 401 Unauthorized. After a successful refresh the attempt is made
+// once more right away, so the callback should update whatever
+// WithHttpReceiverBeforeRequest reads the credentials from. A second 401,
+// or a failed refresh, is returned as the connection error.
+func WithHttpReceiverRefreshAuth(refresh func(ctx context.Context) error) HttpReceiverOption {
+	return func(r *HttpReceiver) {
+		r.refreshAuth = refresh
+	}
+}
+
+// WithHttpReceiverMethod sets the HTTP method used to open the stream, for
+// APIs that stream the response to a POST. The default is GET.
+func WithHttpReceiverMethod(method string) HttpReceiverOption {
+	return func(r *HttpReceiver) {
+		r.req.Method = method
+	}
+}
+
+// WithHttpReceiverBody sets the request body. getBody is called for every
+// connection attempt, so each reconnect sends the full body again.
+func WithHttpReceiverBody(getBody func() (io.ReadCloser, error)) HttpReceiverOption {
+	return func(r *HttpReceiver) {
+		r.getBody = getBody
+	}
+}
+
+// WithHttpReceiverNonResumable marks the stream as one that cannot be
+// resumed, such as the response to a request that starts a job. Attempts
+// to open it are only retried when the request never reached the server,
+// such as a failed dial, or when the server turned it away with a status
+// that is retried, such as 503. A request that fails once sent ends the
+// stream. Once the stream has opened, its end is final too: Receive returns
+// io.EOF (or the read error) instead of sending the request again.
+//
+// Whether a request was sent is reported by the transport through
+// net/http/httptrace; with a RoundTripper that does not report it, failed
+// requests are retried.
+func WithHttpReceiverNonResumable() HttpReceiverOption {
+	return func(r *HttpReceiver) {
+		r.nonResumable = true
+	}
+}
+
+// WithHttpReceiverRetryForever makes the receiver keep reconnecting however
+// many attempts fail, instead of giving up after the count set by
+// WithHttpReceiverRetry. Pair it with a growing Backoff so a long outage
+// does not turn into a tight loop.
+func WithHttpReceiverRetryForever() HttpReceiverOption {
+	return func(r *HttpReceiver) {
+		r.retryForever = true
+	}
+}
+
+// WithHttpReceiverHealthyAfter sets how long a connection must stay up
+// before the attempt counter and backoff are reset. A connection that drops
+// sooner counts as one more failed attempt. By default any successful
+// connection resets them.
+func WithHttpReceiverHealthyAfter(d time.Duration) HttpReceiverOption {
+	return func(r *HttpReceiver) {
+		r.healthyAfter = d
+	}
+}
+
+// WithHttpReceiverOnConnecting sets a callback invoked before each
+// connection attempt, with the number of attempts so far including this
+// one. It runs on the receiving goroutine and should return quickly.
+func WithHttpReceiverOnConnecting(onConnecting func(attempt int)) HttpReceiverOption {
+	return func(r *HttpReceiver) {
+		r.onConnecting = onConnecting
+	}
+}
+
+// WithHttpReceiverOnOpen sets a callback invoked when a connection has been
+// accepted and events are about to be read from it. The receiver owns
+// resp.Body; the callback must not read or close it. It runs on the
+// receiving goroutine and should return quickly.
+func WithHttpReceiverOnOpen(onOpen func(resp *http.Response)) HttpReceiverOption {
+	return func(r *HttpReceiver) {
+		r.onOpen = onOpen
+	}
+}
+
+// WithHttpReceiverOnRetry sets a callback invoked after each failed
+// connection attempt, with the number of attempts so far, the delay before
+// the next one and the reason the last one failed. It is also invoked when
+// a healthy stream drops, with zero attempts and the reason it dropped,
+// before waiting to reconnect. It runs on the receiving goroutine and
+// should return quickly.
+func WithHttpReceiverOnRetry(onRetry func(attempt int, delay time.Duration, err error)) HttpReceiverOption {
+	return func(r *HttpReceiver) {
+		r.onRetry = onRetry
+	}
+}
+
+// WithHttpReceiverMaxRetryAfter caps the delay a Retry-After header on a
+// 429 or 503 response can impose in place of the backoff schedule. The
+// default is 5 minutes; zero ignores Retry-After altogether.
+func WithHttpReceiverMaxRetryAfter(d time.Duration) HttpReceiverOption {
+	return func(r *HttpReceiver) {
+		r.maxRetryAfter = d
+	}
+}
+
+// WithHttpReceiverMaxMessageSize limits the length of a single line and of
+// a whole message, as WithDecoderMaxLineSize and WithDecoderMaxMessageSize
+// do. Zero means no limit. Receive returns an error wrapping
+// ErrMessageTooLarge for each oversized message and the next call picks up
+// with the message after it.
+func WithHttpReceiverMaxMessageSize(maxLine, maxMessage int) HttpReceiverOption {
+	return func(r *HttpReceiver) {
+		r.maxLine = maxLine
+		r.maxMessage = maxMessage
+	}
+}
+
+// WithHttpReceiverReconnectOversized makes the receiver drop the connection
+// after an oversized message and reconnect on the next Receive, instead of
+// reading past the message. Use it when a server that sends such messages
+// is likely to keep sending garbage.
+func WithHttpReceiverReconnectOversized() HttpReceiverOption {
+	return func(r *HttpReceiver) {
+		r.reconnectBig = true
+	}
+}
+
+// WithHttpReceiverIdleTimeout drops the connection and reconnects, sending
+// Last-Event-ID, when a read waits longer than d for any bytes at all,
+// comments included. Set it above the server's ping interval so that a
+// connection silently dropped by a NAT or proxy is noticed. The dropped
+// connection is reported to OnDisconnect with ErrIdleTimeout. Zero, the
+// default, waits forever.
+func WithHttpReceiverIdleTimeout(d time.Duration) HttpReceiverOption {
+	return func(r *HttpReceiver) {
+		r.idleTimeout = d
+	}
+}
+
+// WithHttpReceiverOnDisconnect sets a callback invoked when an open
+// connection ends, with the reason, before any reconnection attempt. It
+// runs on the receiving goroutine and should return quickly.
+func WithHttpReceiverOnDisconnect(onDisconnect func(cause error)) HttpReceiverOption {
+	return func(r *HttpReceiver) {
+		r.onDisconnect = onDisconnect
+	}
+}
+
+// WithHttpReceiverOnClosed sets a callback invoked once when the receiver
+// stops for good: with nil when Close is called, or with the error that
+// ended the stream, such as a 204 No Content response. It runs on the
+// goroutine that calls Close or Receive.
+func WithHttpReceiverOnClosed(onClosed func(err error)) HttpReceiverOption {
+	return func(r *HttpReceiver) {
+		r.onClosed = onClosed
+	}
+}
+
+// WithHttpReceiverBufferPolicy sets what Messages does when its buffer is
+// full. The default is BufferBlock.
+func WithHttpReceiverBufferPolicy(policy BufferPolicy) HttpReceiverOption {
+	return func(r *HttpReceiver) {
+		r.bufferPolicy = policy
+	}
+}
+
 func WithHttpReceiverRespHeader(respHeader func(header http.Header)) HttpReceiverOption {
 	return func(r *HttpReceiver) {
 		r.respHeader = respHeader
@@ -589,6 +1934,21 @@ func WithHttpReceiverRespHeader(respHeader func(header http.Header)) HttpReceive
 }
 
 func CreateHttpReceiver(receiverURL string, opts ...HttpReceiverOption) (*HttpReceiver, error) {
+	receiver, err := newHttpReceiver(receiverURL, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := receiver.connect(nil); err != nil {
+		receiver.cancel()
+		return nil, err
+	}
+
+	return receiver, nil
+}
+
+// newHttpReceiver builds a receiver that connects on its first Receive.
+func newHttpReceiver(receiverURL string, opts ...HttpReceiverOption) (*HttpReceiver, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, receiverURL, nil)
@@ -600,21 +1960,23 @@ func CreateHttpReceiver(receiverURL string, opts ...HttpReceiverOption) (*HttpRe
 	req.Header.Set("Cache-Control", "no-cache")
 
 	receiver := &HttpReceiver{
-		client:     http.DefaultClient,
-		req:        req,
-		retryMax:   3,
-		retryDelay: 1 * time.Second,
-		ctx:        ctx,
-		cancel:     cancel,
+		client:        http.DefaultClient,
+		req:           req,
+		retryMax:      3,
+		retryDelay:    1 * time.Second,
+		maxRetryAfter: defaultMaxRetryAfter,
+		ctx:           ctx,
+		cancel:        cancel,
+		recvSem:       make(chan struct{}, 1),
+		results:       make(chan recvResult, 1),
 	}
 
 	for _, opt := range opts {
 		opt(receiver)
 	}
 
-	if err := receiver.connect(); err != nil {
-		cancel()
-		return nil, err
+	if receiver.backoff == nil {
+		receiver.backoff = NewConstantBackoff(receiver.retryDelay)
 	}
 
 	return receiver, nil
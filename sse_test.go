@@ -3,15 +3,20 @@ package sse
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
+	"testing/iotest"
 	"time"
 )
 
@@ -83,9 +88,14 @@ func TestReadMessage(t *testing.T) {
 			want:  &Message{Id: "42", Event: "update", Data: "line1\nline2"},
 		},
 		{
-			name:  "parses comment as data",
+			name:  "parses comment",
 			input: ": heartbeat\n\n",
-			want:  &Message{Data: "heartbeat"},
+			want:  &Message{Comment: "heartbeat"},
+		},
+		{
+			name:  "keeps comments apart from data",
+			input: ": one\ndata: payload\n:two\n\n",
+			want:  &Message{Data: "payload", Comment: "one\ntwo"},
 		},
 		{
 			name:  "skips leading blanks",
@@ -104,9 +114,24 @@ func TestReadMessage(t *testing.T) {
 		},
 		{
 			name:    "unknown fields are ignored",
-			input:   "retry: 1000\n\n",
+			input:   "foo: bar\n\n",
 			wantErr: io.EOF,
 		},
+		{
+			name:  "parses retry",
+			input: "retry: 1500\ndata: ok\n\n",
+			want:  &Message{Data: "ok", Retry: 1500 * time.Millisecond},
+		},
+		{
+			name:  "parses retry only block",
+			input: "retry: 1000\n\n",
+			want:  &Message{Retry: time.Second},
+		},
+		{
+			name:  "ignores retry with non digits",
+			input: "retry: 10s\nretry: -5\nretry: 1.5\nretry:\ndata: ok\n\n",
+			want:  &Message{Data: "ok"},
+		},
 		{
 			name:  "invalid id containing nul is ignored",
 			input: "id: valid\nid: bad\x00id\ndata: payload\n\n",
@@ -117,6 +142,21 @@ func TestReadMessage(t *testing.T) {
 			input: "id: 7\r\nevent: ping\r\ndata: ok\r\n\r\n",
 			want:  &Message{Id: "7", Event: "ping", Data: "ok"},
 		},
+		{
+			name:  "supports bare cr line endings",
+			input: "id: 7\revent: ping\rdata: a\rdata: b\r\r",
+			want:  &Message{Id: "7", Event: "ping", Data: "a\nb"},
+		},
+		{
+			name:  "supports mixed line endings",
+			input: "id: 7\r\ndata: a\rdata: b\ndata: c\r\n\r",
+			want:  &Message{Id: "7", Data: "a\nb\nc"},
+		},
+		{
+			name:  "strips leading bom",
+			input: "\xEF\xBB\xBFdata: ok\n\n",
+			want:  &Message{Data: "ok"},
+		},
 	}
 
 	for _, tt := range tests {
@@ -170,6 +210,186 @@ func TestReadMessageLongLine(t *testing.T) {
 	}
 }
 
+func TestReadMessageLineEndingsAcrossRefills(t *testing.T) {
+	t.Parallel()
+
+	input := "id: 1\r\ndata: one\r\n\r\nid: 2\rdata: two\r\rid: 3\ndata: three\n\n"
+	want := []Message{
+		{Id: "1", Data: "one"},
+		{Id: "2", Data: "two"},
+		{Id: "3", Data: "three"},
+	}
+
+	// One byte per Read forces every CRLF to be split across refills.
+	br := bufio.NewReaderSize(iotest.OneByteReader(strings.NewReader(input)), 16)
+	for i, w := range want {
+		got, err := ReadMessage(br)
+		if err != nil {
+			t.Fatalf("ReadMessage() #%d error = %v", i, err)
+		}
+		if *got != w {
+			t.Fatalf("ReadMessage() #%d = %#v, want %#v", i, *got, w)
+		}
+	}
+	if _, err := ReadMessage(br); err != io.EOF {
+		t.Fatalf("final ReadMessage() error = %v, want %v", err, io.EOF)
+	}
+}
+
+func TestReadMessageLongLineBareCR(t *testing.T) {
+	t.Parallel()
+
+	payload := strings.Repeat("x", 1000)
+	input := "data: " + payload + "\rdata: " + payload + "\r\r"
+	br := bufio.NewReaderSize(strings.NewReader(input), 64)
+
+	msg, err := ReadMessage(br)
+	if err != nil {
+		t.Fatalf("ReadMessage() unexpected error = %v", err)
+	}
+	if msg.Data != payload+"\n"+payload {
+		t.Fatalf("Data length = %d, want %d", len(msg.Data), 2*len(payload)+1)
+	}
+}
+
+func TestDecoderDecode(t *testing.T) {
+	t.Parallel()
+
+	input := "id: 1\nevent: a\ndata: one\n\n: ping\n\nid: 2\r\ndata: two\r\ndata: lines\r\n\r\n"
+	dec := NewDecoder(strings.NewReader(input), WithDecoderBufferSize(16))
+
+	want := []struct {
+		msg    Message
+		line   int
+		offset int64
+	}{
+		{msg: Message{Id: "1", Event: "a", Data: "one"}, line: 4, offset: 26},
+		{msg: Message{Comment: "ping"}, line: 6, offset: 34},
+		{msg: Message{Id: "2", Data: "two\nlines"}, line: 10, offset: int64(len(input))},
+	}
+
+	var msg Message
+	for i, w := range want {
+		if err := dec.Decode(&msg); err != nil {
+			t.Fatalf("Decode() #%d error = %v", i, err)
+		}
+		if msg != w.msg {
+			t.Fatalf("Decode() #%d = %#v, want %#v", i, msg, w.msg)
+		}
+		if dec.Line() != w.line || dec.InputOffset() != w.offset {
+			t.Fatalf("Decode() #%d position = line %d offset %d, want line %d offset %d",
+				i, dec.Line(), dec.InputOffset(), w.line, w.offset)
+		}
+	}
+	if err := dec.Decode(&msg); err != io.EOF {
+		t.Fatalf("final Decode() error = %v, want %v", err, io.EOF)
+	}
+
+	dec.Reset(strings.NewReader("data: again\n\n"))
+	if err := dec.Decode(&msg); err != nil {
+		t.Fatalf("Decode() after Reset error = %v", err)
+	}
+	if msg != (Message{Data: "again"}) || dec.Line() != 2 {
+		t.Fatalf("Decode() after Reset = %#v at line %d", msg, dec.Line())
+	}
+}
+
+func TestDecoderDecodeAllocations(t *testing.T) {
+	var input strings.Builder
+	for i := range 1000 {
+		fmt.Fprintf(&input, "id: %d\nevent: quote\ndata: {\"symbol\":\"ACME\",\"price\":%d.25}\ndata: {\"venue\":\"XNAS\"}\n\n", 100000+i, i)
+	}
+	dec := NewDecoder(strings.NewReader(input.String()))
+
+	var msg Message
+	allocs := testing.AllocsPerRun(100, func() {
+		if err := dec.Decode(&msg); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+	})
+	// Only the id and the joined data strings are allocated; the event name
+	// is reused and the accumulators are kept between calls.
+	if allocs > 2 {
+		t.Fatalf("Decode() allocations = %v, want at most 2", allocs)
+	}
+}
+
+func TestDecoderSizeLimits(t *testing.T) {
+	t.Parallel()
+
+	long := strings.Repeat("x", 100)
+
+	tests := []struct {
+		name  string
+		input string
+		opts  []DecoderOption
+		want  []*Message // nil entries expect ErrMessageTooLarge
+	}{
+		{
+			name:  "long line spanning buffers",
+			input: "data: " + long + "\n\ndata: ok\n\n",
+			opts:  []DecoderOption{WithDecoderBufferSize(16), WithDecoderMaxLineSize(20)},
+			want:  []*Message{nil, {Data: "ok"}},
+		},
+		{
+			name:  "long line within buffer",
+			input: "data: " + long + "\r\nid: 1\r\n\r\ndata: ok\r\n\r\n",
+			opts:  []DecoderOption{WithDecoderMaxLineSize(20)},
+			want:  []*Message{nil, {Data: "ok"}},
+		},
+		{
+			name:  "line never ends",
+			input: "data: " + long + long + long,
+			opts:  []DecoderOption{WithDecoderBufferSize(16), WithDecoderMaxLineSize(20)},
+			want:  []*Message{nil},
+		},
+		{
+			name:  "message over limit",
+			input: "data: aaaa\ndata: bbbb\ndata: cccc\n\ndata: ok\n\n",
+			opts:  []DecoderOption{WithDecoderMaxMessageSize(25)},
+			want:  []*Message{nil, {Data: "ok"}},
+		},
+		{
+			name:  "at the limits",
+			input: "data: aaaa\ndata: bbbb\n\n",
+			opts:  []DecoderOption{WithDecoderMaxLineSize(10), WithDecoderMaxMessageSize(20)},
+			want:  []*Message{{Data: "aaaa\nbbbb"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			dec := NewDecoder(strings.NewReader(tt.input), tt.opts...)
+			var msg Message
+			for i, want := range tt.want {
+				err := dec.Decode(&msg)
+				if want == nil {
+					if !errors.Is(err, ErrMessageTooLarge) {
+						t.Fatalf("Decode() #%d error = %v, want %v", i, err, ErrMessageTooLarge)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("Decode() #%d error = %v", i, err)
+				}
+				if msg != *want {
+					t.Fatalf("Decode() #%d = %#v, want %#v", i, msg, *want)
+				}
+			}
+			if err := dec.Decode(&msg); err != io.EOF {
+				t.Fatalf("final Decode() error = %v, want %v", err, io.EOF)
+			}
+		})
+	}
+
+	_, err := ReadMessage(strings.NewReader("data: "+long+"\n\n"), WithDecoderMaxLineSize(20))
+	if !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("ReadMessage() error = %v, want %v", err, ErrMessageTooLarge)
+	}
+}
+
 func TestWriteMessage(t *testing.T) {
 	t.Parallel()
 
@@ -193,6 +413,31 @@ func TestWriteMessage(t *testing.T) {
 			msg:  NewComment("keepalive\nsecond"),
 			want: ": keepalive\n: second\n\n",
 		},
+		{
+			name: "writes retry",
+			msg:  &Message{Retry: 2500 * time.Millisecond, Data: "hello"},
+			want: "retry: 2500\ndata: hello\n\n",
+		},
+		{
+			name: "rounds sub-millisecond retry up",
+			msg:  &Message{Retry: 500 * time.Microsecond, Data: "hello"},
+			want: "retry: 1\ndata: hello\n\n",
+		},
+		{
+			name: "writes data only message as event",
+			msg:  &Message{Data: "hello"},
+			want: "data: hello\n\n",
+		},
+		{
+			name: "writes comment alongside event",
+			msg:  &Message{Comment: "note", Event: "update", Data: "hello"},
+			want: ": note\nevent: update\ndata: hello\n\n",
+		},
+		{
+			name: "splits data on cr lf and crlf",
+			msg:  &Message{Data: "a\rb\r\nc\nd"},
+			want: "data: a\ndata: b\ndata: c\ndata: d\n\n",
+		},
 		{
 			name: "writes blank message",
 			msg:  &Message{},
@@ -218,6 +463,192 @@ func TestWriteMessage(t *testing.T) {
 	}
 }
 
+func TestWriteMessageRejectsInvalidFields(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		msg  *Message
+	}{
+		{name: "newline in id", msg: &Message{Id: "1\ndata: injected", Data: "x"}},
+		{name: "carriage return in id", msg: &Message{Id: "1\r", Data: "x"}},
+		{name: "nul in id", msg: &Message{Id: "1\x00", Data: "x"}},
+		{name: "newline in event", msg: &Message{Event: "a\n\ndata: injected", Data: "x"}},
+		{name: "carriage return in event", msg: &Message{Event: "a\rb", Data: "x"}},
+		{name: "negative retry", msg: &Message{Retry: -time.Second}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var out bytes.Buffer
+			var scratch bytes.Buffer
+			err := WriteMessage(&out, tt.msg, &scratch)
+			if !errors.Is(err, ErrInvalidField) {
+				t.Fatalf("WriteMessage() error = %v, want %v", err, ErrInvalidField)
+			}
+			if out.Len() != 0 || scratch.Len() != 0 {
+				t.Fatalf("WriteMessage() wrote %q after rejecting the message", out.String()+scratch.String())
+			}
+		})
+	}
+}
+
+type flushCountingWriter struct {
+	bytes.Buffer
+	writes  int
+	flushes int
+}
+
+func (w *flushCountingWriter) Write(b []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(b)
+}
+
+func (w *flushCountingWriter) Flush() error {
+	w.flushes++
+	return nil
+}
+
+func TestEncoderEncode(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	enc := NewEncoder(&out)
+
+	msg := &Message{Id: "1", Event: "update", Data: "a\nb", Comment: "note", Retry: time.Second}
+	if err := enc.Encode(msg, Field{Name: "x-trace", Value: "abc"}); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if err := enc.WriteComment("ping"); err != nil {
+		t.Fatalf("WriteComment() error = %v", err)
+	}
+
+	want := ": note\nid: 1\nevent: update\nretry: 1000\nx-trace: abc\ndata: a\ndata: b\n\n: ping\n\n"
+	if out.String() != want {
+		t.Fatalf("output = %q, want %q", out.String(), want)
+	}
+
+	for _, f := range []Field{{Name: ""}, {Name: "a:b"}, {Name: "x", Value: "1\n2"}} {
+		if err := enc.Encode(&Message{Data: "x"}, f); !errors.Is(err, ErrInvalidField) {
+			t.Fatalf("Encode() with field %#v error = %v, want %v", f, err, ErrInvalidField)
+		}
+	}
+	if out.String() != want {
+		t.Fatalf("rejected messages were written: %q", out.String())
+	}
+
+	// The output must round-trip through the decoder.
+	dec := NewDecoder(strings.NewReader(want))
+	var got Message
+	if err := dec.Decode(&got); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if got != *msg {
+		t.Fatalf("Decode() = %#v, want %#v", got, *msg)
+	}
+}
+
+func TestEncoderBuffered(t *testing.T) {
+	t.Parallel()
+
+	w := &flushCountingWriter{}
+	enc := NewEncoder(w, WithEncoderBuffer(1024))
+
+	for i := range 3 {
+		if err := enc.Encode(&Message{Id: strconv.Itoa(i), Data: "x"}); err != nil {
+			t.Fatalf("Encode() error = %v", err)
+		}
+	}
+	if w.writes != 0 || enc.Buffered() == 0 {
+		t.Fatalf("buffered encoder wrote early: writes=%d buffered=%d", w.writes, enc.Buffered())
+	}
+
+	if err := enc.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if w.writes != 1 || w.flushes != 1 || enc.Buffered() != 0 {
+		t.Fatalf("after Flush() writes=%d flushes=%d buffered=%d, want 1 1 0", w.writes, w.flushes, enc.Buffered())
+	}
+	if want := "id: 0\ndata: x\n\nid: 1\ndata: x\n\nid: 2\ndata: x\n\n"; w.String() != want {
+		t.Fatalf("output = %q, want %q", w.String(), want)
+	}
+
+	small := &flushCountingWriter{}
+	enc = NewEncoder(small, WithEncoderBuffer(8))
+	if err := enc.Encode(&Message{Data: "longer than eight bytes"}); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if small.writes != 1 {
+		t.Fatalf("encoder did not write once its buffer filled: writes=%d", small.writes)
+	}
+}
+
+func TestFrameReuse(t *testing.T) {
+	t.Parallel()
+
+	frame, err := NewFrame(&Message{Id: "7", Data: "shared"})
+	if err != nil {
+		t.Fatalf("NewFrame() error = %v", err)
+	}
+	if _, err := NewFrame(&Message{Event: "a\nb"}); !errors.Is(err, ErrInvalidField) {
+		t.Fatalf("NewFrame() error = %v, want %v", err, ErrInvalidField)
+	}
+
+	const want = "id: 7\ndata: shared\n\n"
+	for range 2 {
+		w := &recordingResponseWriter{}
+		pusher, err := CreateHttpPusher(w)
+		if err != nil {
+			t.Fatalf("CreateHttpPusher() error = %v", err)
+		}
+		if err := pusher.PushFrame(frame); err != nil {
+			t.Fatalf("PushFrame() error = %v", err)
+		}
+		_ = pusher.Close()
+		if out, _ := w.snapshot(); out != want {
+			t.Fatalf("PushFrame() wrote %q, want %q", out, want)
+		}
+	}
+}
+
+func TestHttpPusherSanitize(t *testing.T) {
+	t.Parallel()
+
+	msg := &Message{Id: "1\r\n2", Event: "up\ndate", Data: "x"}
+
+	strict := &recordingResponseWriter{}
+	pusher, err := CreateHttpPusher(strict)
+	if err != nil {
+		t.Fatalf("CreateHttpPusher() error = %v", err)
+	}
+	defer pusher.Close()
+
+	if err := pusher.Push(msg); !errors.Is(err, ErrInvalidField) {
+		t.Fatalf("Push() error = %v, want %v", err, ErrInvalidField)
+	}
+	if out, _ := strict.snapshot(); out != "" {
+		t.Fatalf("Push() wrote %q after rejecting the message", out)
+	}
+
+	lenient := &recordingResponseWriter{}
+	sanitizing, err := CreateHttpPusher(lenient, WithHttpPusherSanitize())
+	if err != nil {
+		t.Fatalf("CreateHttpPusher() error = %v", err)
+	}
+	defer sanitizing.Close()
+
+	if err := sanitizing.Push(msg); err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+	want := "id: 12\nevent: update\ndata: x\n\n"
+	if out, _ := lenient.snapshot(); out != want {
+		t.Fatalf("Push() wrote %q, want %q", out, want)
+	}
+}
+
 func TestHttpReceiverReceiveMultipleMessages(t *testing.T) {
 	t.Parallel()
 
@@ -316,17 +747,12 @@ func TestHttpReceiverReconnectSendsLastEventID(t *testing.T) {
 	}
 }
 
-func TestHttpReceiverCloseUnblocksReceive(t *testing.T) {
+func TestHttpReceiverSkipsComments(t *testing.T) {
 	t.Parallel()
 
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		pusher, err := CreateHttpPusher(w)
-		if err != nil {
-			return
-		}
-		defer pusher.Close()
-
-		<-req.Context().Done()
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, ": ping\n\ndata: first\n\n")
 	}))
 	defer server.Close()
 
@@ -338,242 +764,1187 @@ func TestHttpReceiverCloseUnblocksReceive(t *testing.T) {
 	if err != nil {
 		t.Fatalf("CreateHttpReceiver() error = %v", err)
 	}
-
-	errCh := make(chan error, 1)
-	go func() {
-		_, recvErr := receiver.Receive()
-		errCh <- recvErr
+	defer func() {
+		_ = receiver.Close()
 	}()
 
-	time.Sleep(50 * time.Millisecond)
-
-	if err := receiver.Close(); err != nil {
-		t.Fatalf("Close() error = %v", err)
+	msg, err := receiver.Receive()
+	if err != nil {
+		t.Fatalf("Receive() error = %v", err)
 	}
-
-	select {
-	case err := <-errCh:
-		if !errors.Is(err, http.ErrServerClosed) {
-			t.Fatalf("Receive() error = %v, want %v", err, http.ErrServerClosed)
-		}
-	case <-time.After(time.Second):
-		t.Fatal("Receive() did not unblock after Close()")
+	if msg.Data != "first" || msg.Comment != "" {
+		t.Fatalf("Receive() = %#v, want data=first", msg)
 	}
 }
 
-func TestHttpPusherCloseClosesUnderlyingWriter(t *testing.T) {
+func TestHttpReceiverOversizedMessages(t *testing.T) {
 	t.Parallel()
 
-	w := &closeTrackingResponseWriter{}
-	pusher, err := CreateHttpPusher(w)
-	if err != nil {
-		t.Fatalf("CreateHttpPusher() error = %v", err)
+	tests := []struct {
+		name      string
+		opts      []HttpReceiverOption
+		wantData  string
+		wantConns int32
+	}{
+		{name: "skip", wantData: "after", wantConns: 1},
+		{name: "reconnect", opts: []HttpReceiverOption{WithHttpReceiverReconnectOversized()}, wantData: "retried", wantConns: 2},
 	}
 
-	if err := pusher.Push(&Message{Data: "hello"}); err != nil {
-		t.Fatalf("Push() error = %v", err)
-	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
 
-	if err := pusher.Close(); err != nil {
-		t.Fatalf("Close() error = %v", err)
-	}
-	if !w.closed.Load() {
-		t.Fatal("Close() did not close underlying writer")
+			var connCount atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				if connCount.Add(1) > 1 {
+					_, _ = io.WriteString(w, "data: retried\n\n")
+					return
+				}
+				_, _ = io.WriteString(w, "data: "+strings.Repeat("x", 1000)+"\n\ndata: after\n\n")
+			}))
+			defer server.Close()
+
+			opts := append([]HttpReceiverOption{
+				WithHttpReceiverClient(server.Client()),
+				WithHttpReceiverRetry(1, 0),
+				WithHttpReceiverMaxMessageSize(100, 0),
+			}, tt.opts...)
+			receiver, err := CreateHttpReceiver(server.URL, opts...)
+			if err != nil {
+				t.Fatalf("CreateHttpReceiver() error = %v", err)
+			}
+			defer func() {
+				_ = receiver.Close()
+			}()
+
+			if _, err := receiver.Receive(); !errors.Is(err, ErrMessageTooLarge) {
+				t.Fatalf("Receive() error = %v, want %v", err, ErrMessageTooLarge)
+			}
+			msg, err := receiver.Receive()
+			if err != nil {
+				t.Fatalf("Receive() error = %v", err)
+			}
+			if msg.Data != tt.wantData {
+				t.Fatalf("Receive() data = %q, want %q", msg.Data, tt.wantData)
+			}
+			if got := connCount.Load(); got != tt.wantConns {
+				t.Fatalf("connections = %d, want %d", got, tt.wantConns)
+			}
+		})
+	}
+}
+
+func TestHttpReceiverIdleTimeout(t *testing.T) {
+	t.Parallel()
+
+	var connCount atomic.Int32
+	lastEventID := make(chan string, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		if connCount.Add(1) > 1 {
+			lastEventID <- req.Header.Get("Last-Event-ID")
+			_, _ = io.WriteString(w, "id: 2\ndata: second\n\n")
+			return
+		}
+		_, _ = io.WriteString(w, "id: 1\ndata: first\n\n")
+		w.(http.Flusher).Flush()
+		// Stall like a connection whose packets are being dropped.
+		<-req.Context().Done()
+	}))
+	defer server.Close()
+
+	causes := make(chan error, 1)
+	receiver, err := CreateHttpReceiver(
+		server.URL,
+		WithHttpReceiverClient(server.Client()),
+		WithHttpReceiverRetry(1, 0),
+		WithHttpReceiverIdleTimeout(50*time.Millisecond),
+		WithHttpReceiverOnDisconnect(func(cause error) {
+			causes <- cause
+		}),
+	)
+	if err != nil {
+		t.Fatalf("CreateHttpReceiver() error = %v", err)
+	}
+	defer func() {
+		_ = receiver.Close()
+	}()
+
+	// A consumer that is slow to call Receive must not trip the watchdog.
+	time.Sleep(100 * time.Millisecond)
+
+	for _, want := range []string{"first", "second"} {
+		msg, err := receiver.Receive()
+		if err != nil {
+			t.Fatalf("Receive() error = %v", err)
+		}
+		if msg.Data != want {
+			t.Fatalf("Receive() data = %q, want %q", msg.Data, want)
+		}
+	}
+
+	if got := <-lastEventID; got != "1" {
+		t.Fatalf("Last-Event-ID = %q, want %q", got, "1")
+	}
+	if cause := <-causes; !errors.Is(cause, ErrIdleTimeout) {
+		t.Fatalf("OnDisconnect() cause = %v, want %v", cause, ErrIdleTimeout)
+	}
+}
+
+func TestHttpReceiverHonorsServerRetry(t *testing.T) {
+	t.Parallel()
+
+	var connCount atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch connCount.Add(1) {
+		case 1:
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = io.WriteString(w, "retry: 10\n\nid: 1\ndata: first\n\n")
+		case 2:
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		default:
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = io.WriteString(w, "id: 2\ndata: second\n\n")
+		}
+	}))
+	defer server.Close()
+
+	receiver, err := CreateHttpReceiver(
+		server.URL,
+		WithHttpReceiverClient(server.Client()),
+		WithHttpReceiverRetry(3, time.Hour),
+	)
+	if err != nil {
+		t.Fatalf("CreateHttpReceiver() error = %v", err)
+	}
+	defer func() {
+		_ = receiver.Close()
+	}()
+
+	msg1, err := receiver.Receive()
+	if err != nil {
+		t.Fatalf("first Receive() error = %v", err)
+	}
+	if msg1.Id != "1" || msg1.Data != "first" {
+		t.Fatalf("first Receive() = %#v, want id=1 data=first (retry-only block must be skipped)", msg1)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		msg2, err := receiver.Receive()
+		if err != nil {
+			t.Errorf("second Receive() error = %v", err)
+			return
+		}
+		if msg2.Id != "2" {
+			t.Errorf("second Receive() = %#v, want id=2", msg2)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("reconnect did not use the server-sent retry delay")
+	}
+}
+
+func TestHttpReceiverWaitsServerRetryAfterDrop(t *testing.T) {
+	t.Parallel()
+
+	const retry = 200 * time.Millisecond
+
+	var (
+		connCount atomic.Int32
+		droppedAt atomic.Int64
+	)
+	reconnected := make(chan time.Time, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if connCount.Add(1) > 1 {
+			reconnected <- time.Now()
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "retry: 200\nid: 1\ndata: first\n\n")
+		droppedAt.Store(time.Now().UnixNano())
+	}))
+	defer server.Close()
+
+	receiver, err := CreateHttpReceiver(
+		server.URL,
+		WithHttpReceiverClient(server.Client()),
+		WithHttpReceiverRetry(3, time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("CreateHttpReceiver() error = %v", err)
+	}
+	defer receiver.Close()
+
+	if _, err := receiver.Receive(); err != nil {
+		t.Fatalf("Receive() error = %v", err)
+	}
+	go func() {
+		_, _ = receiver.Receive()
+	}()
+
+	select {
+	case at := <-reconnected:
+		if gap := at.Sub(time.Unix(0, droppedAt.Load())); gap < retry {
+			t.Fatalf("reconnected %v after the stream dropped, want at least %v", gap, retry)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("receiver did not reconnect")
+	}
+}
+
+func TestHttpReceiverHonorsZeroServerRetry(t *testing.T) {
+	t.Parallel()
+
+	var connCount atomic.Int32
+	reconnected := make(chan struct{}, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if connCount.Add(1) > 1 {
+			reconnected <- struct{}{}
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "retry: 0\nid: 1\ndata: first\n\n")
+	}))
+	defer server.Close()
+
+	// retry: 0 replaces the long configured delay, as in browsers.
+	receiver, err := CreateHttpReceiver(
+		server.URL,
+		WithHttpReceiverClient(server.Client()),
+		WithHttpReceiverRetry(3, time.Minute),
+	)
+	if err != nil {
+		t.Fatalf("CreateHttpReceiver() error = %v", err)
+	}
+	defer receiver.Close()
+
+	if _, err := receiver.Receive(); err != nil {
+		t.Fatalf("Receive() error = %v", err)
+	}
+	go func() {
+		_, _ = receiver.Receive()
+	}()
+
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("receiver did not reconnect at once after retry: 0")
+	}
+}
+
+func TestHttpReceiverReceiveContext(t *testing.T) {
+	t.Parallel()
+
+	var connCount atomic.Int32
+	release := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		connCount.Add(1)
+		pusher, err := CreateHttpPusher(w)
+		if err != nil {
+			return
+		}
+		defer pusher.Close()
+
+		_ = pusher.Push(&Message{Id: "1", Data: "first"})
+		select {
+		case <-release:
+		case <-req.Context().Done():
+			return
+		}
+		_ = pusher.Push(&Message{Id: "2", Data: "second"})
+		<-req.Context().Done()
+	}))
+	defer server.Close()
+
+	receiver, err := CreateHttpReceiver(
+		server.URL,
+		WithHttpReceiverClient(server.Client()),
+		WithHttpReceiverRetry(1, 0),
+	)
+	if err != nil {
+		t.Fatalf("CreateHttpReceiver() error = %v", err)
+	}
+	defer func() {
+		_ = receiver.Close()
+	}()
+
+	msg, err := receiver.ReceiveContext(context.Background())
+	if err != nil || msg.Id != "1" {
+		t.Fatalf("first ReceiveContext() = %#v, %v, want id=1", msg, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := receiver.ReceiveContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ReceiveContext() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	cancelled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	if _, err := receiver.ReceiveContext(cancelled); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ReceiveContext() error = %v, want %v", err, context.Canceled)
+	}
+
+	close(release)
+
+	msg, err = receiver.Receive()
+	if err != nil || msg.Id != "2" {
+		t.Fatalf("Receive() after cancellation = %#v, %v, want id=2", msg, err)
+	}
+	if n := connCount.Load(); n != 1 {
+		t.Fatalf("connections = %d, want 1 (cancellation must not drop the connection)", n)
+	}
+}
+
+func TestHttpReceiverReceiveInto(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		pusher, err := CreateHttpPusher(w)
+		if err != nil {
+			return
+		}
+		defer pusher.Close()
+
+		_ = pusher.Push(&Message{Id: "1", Event: "tick", Data: "first"})
+		_ = pusher.Push(&Message{Id: "2", Data: "second"})
+		select {
+		case <-release:
+		case <-req.Context().Done():
+			return
+		}
+		_ = pusher.Push(&Message{Id: "3", Data: "third"})
+		<-req.Context().Done()
+	}))
+	defer server.Close()
+
+	receiver, err := CreateHttpReceiver(
+		server.URL,
+		WithHttpReceiverClient(server.Client()),
+		WithHttpReceiverRetry(1, 0),
+	)
+	if err != nil {
+		t.Fatalf("CreateHttpReceiver() error = %v", err)
+	}
+	defer func() {
+		_ = receiver.Close()
+	}()
+
+	var msg Message
+	if err := receiver.ReceiveInto(context.Background(), &msg); err != nil {
+		t.Fatalf("ReceiveInto() error = %v", err)
+	}
+	if msg.Id != "1" || msg.Event != "tick" || msg.Data != "first" {
+		t.Fatalf("first ReceiveInto() = %#v, want id=1 event=tick data=first", msg)
+	}
+
+	// The message is reused: fields absent from the next event are reset.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := receiver.ReceiveInto(ctx, &msg); err != nil {
+		t.Fatalf("ReceiveInto() error = %v", err)
+	}
+	if msg.Id != "2" || msg.Event != "" || msg.Data != "second" {
+		t.Fatalf("second ReceiveInto() = %#v, want id=2 data=second and no event", msg)
+	}
+
+	timeout, cancelTimeout := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelTimeout()
+	if err := receiver.ReceiveInto(timeout, &msg); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ReceiveInto() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	close(release)
+
+	if err := receiver.ReceiveInto(context.Background(), &msg); err != nil || msg.Id != "3" {
+		t.Fatalf("ReceiveInto() after cancellation = %#v, %v, want id=3", msg, err)
+	}
+}
+
+func TestHttpReceiverCloseUnblocksReceive(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		pusher, err := CreateHttpPusher(w)
+		if err != nil {
+			return
+		}
+		defer pusher.Close()
+
+		<-req.Context().Done()
+	}))
+	defer server.Close()
+
+	receiver, err := CreateHttpReceiver(
+		server.URL,
+		WithHttpReceiverClient(server.Client()),
+		WithHttpReceiverRetry(1, 0),
+	)
+	if err != nil {
+		t.Fatalf("CreateHttpReceiver() error = %v", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, recvErr := receiver.Receive()
+		errCh <- recvErr
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	if err := receiver.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, http.ErrServerClosed) {
+			t.Fatalf("Receive() error = %v, want %v", err, http.ErrServerClosed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Receive() did not unblock after Close()")
+	}
+}
+
+func TestHttpPusherCloseClosesUnderlyingWriter(t *testing.T) {
+	t.Parallel()
+
+	w := &closeTrackingResponseWriter{}
+	pusher, err := CreateHttpPusher(w)
+	if err != nil {
+		t.Fatalf("CreateHttpPusher() error = %v", err)
+	}
+
+	if err := pusher.Push(&Message{Data: "hello"}); err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+
+	if err := pusher.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if !w.closed.Load() {
+		t.Fatal("Close() did not close underlying writer")
 	}
 
 	if err := pusher.Push(&Message{Data: "again"}); !errors.Is(err, http.ErrServerClosed) {
 		t.Fatalf("Push() after Close() error = %v, want %v", err, http.ErrServerClosed)
 	}
-	if err := pusher.Close(); !errors.Is(err, http.ErrServerClosed) {
+	if err := pusher.Close(); !errors.Is(err, http.ErrServerClosed) {
+		t.Fatalf("second Close() error = %v, want %v", err, http.ErrServerClosed)
+	}
+}
+
+type recordingResponseWriter struct {
+	mux      sync.Mutex
+	header   http.Header
+	buf      bytes.Buffer
+	writes   int
+	writeErr error
+}
+
+func (w *recordingResponseWriter) Header() http.Header {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if w.header == nil {
+		w.header = make(http.Header)
+	}
+	return w.header
+}
+
+func (w *recordingResponseWriter) Write(b []byte) (int, error) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	w.writes++
+	if w.writeErr != nil {
+		return 0, w.writeErr
+	}
+	return w.buf.Write(b)
+}
+
+func (w *recordingResponseWriter) WriteHeader(statusCode int) {}
+
+func (w *recordingResponseWriter) Flush() {}
+
+func (w *recordingResponseWriter) snapshot() (string, int) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	return w.buf.String(), w.writes
+}
+
+func TestHttpPusherPingFiresWithoutPush(t *testing.T) {
+	t.Parallel()
+
+	w := &recordingResponseWriter{}
+	pusher, err := CreateHttpPusher(w, WithHttpPusherPingDuration(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("CreateHttpPusher() error = %v", err)
+	}
+	defer pusher.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		out, _ := w.snapshot()
+		if strings.Contains(out, ": ping\n\n") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("ping was never written without an initial Push, got %q", out)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHttpPusherPingStopsAfterClose(t *testing.T) {
+	t.Parallel()
+
+	w := &recordingResponseWriter{}
+	pusher, err := CreateHttpPusher(w, WithHttpPusherPingDuration(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("CreateHttpPusher() error = %v", err)
+	}
+
+	if err := pusher.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	_, before := w.snapshot()
+	time.Sleep(50 * time.Millisecond)
+	_, after := w.snapshot()
+	if after != before {
+		t.Fatalf("pings kept firing after Close(): writes %d -> %d", before, after)
+	}
+}
+
+func TestHttpPusherPingStopsAfterWriteError(t *testing.T) {
+	t.Parallel()
+
+	w := &recordingResponseWriter{writeErr: io.ErrClosedPipe}
+	pusher, err := CreateHttpPusher(w, WithHttpPusherPingDuration(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("CreateHttpPusher() error = %v", err)
+	}
+	defer pusher.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, writes := w.snapshot(); writes >= 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("first ping never attempted")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if _, writes := w.snapshot(); writes > 1 {
+		t.Fatalf("ping chain kept re-arming after write error: %d write attempts", writes)
+	}
+}
+
+func TestHttpReceiverCloseClosesUnderlyingBody(t *testing.T) {
+	t.Parallel()
+
+	body := &closeTrackingReadCloser{}
+	client := &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": {"text/event-stream"}},
+				Body:       body,
+			}, nil
+		}),
+	}
+
+	receiver, err := CreateHttpReceiver("http://example.com/sse", WithHttpReceiverClient(client))
+	if err != nil {
+		t.Fatalf("CreateHttpReceiver() error = %v", err)
+	}
+
+	if err := receiver.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if !body.closed.Load() {
+		t.Fatal("Close() did not close underlying body")
+	}
+
+	if err := receiver.Close(); !errors.Is(err, http.ErrServerClosed) {
 		t.Fatalf("second Close() error = %v, want %v", err, http.ErrServerClosed)
 	}
 }
 
-type recordingResponseWriter struct {
-	mux      sync.Mutex
-	header   http.Header
-	buf      bytes.Buffer
-	writes   int
-	writeErr error
+func TestHttpReceiverReceiveReturnsErrorWhenReconnectFails(t *testing.T) {
+	t.Parallel()
+
+	var connCount atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		if connCount.Add(1) == 1 {
+			_, _ = io.WriteString(w, "id: 1\ndata: first\n\n")
+			return
+		}
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+
+	client := server.Client()
+	url := server.URL
+
+	receiver, err := CreateHttpReceiver(
+		url,
+		WithHttpReceiverClient(client),
+		WithHttpReceiverRetry(2, 10*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("CreateHttpReceiver() error = %v", err)
+	}
+
+	msg, err := receiver.Receive()
+	if err != nil {
+		t.Fatalf("first Receive() error = %v", err)
+	}
+	if msg.Id != "1" || msg.Data != "first" {
+		t.Fatalf("first Receive() = %#v, want id=1 data=first", msg)
+	}
+
+	server.Close()
+
+	_, err = receiver.Receive()
+	if err == nil {
+		t.Fatal("second Receive() expected reconnect failure, got nil")
+	}
+	if !strings.Contains(err.Error(), "failed to connect") {
+		t.Fatalf("second Receive() error = %v, want failed to connect", err)
+	}
+
+	_ = receiver.Close()
 }
 
-func (w *recordingResponseWriter) Header() http.Header {
-	w.mux.Lock()
-	defer w.mux.Unlock()
-	if w.header == nil {
-		w.header = make(http.Header)
+func TestHttpReceiverRetryForever(t *testing.T) {
+	t.Parallel()
+
+	var connCount atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if connCount.Add(1) <= 5 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "data: finally\n\n")
+	}))
+	defer server.Close()
+
+	var retries []int
+	receiver, err := CreateHttpReceiver(
+		server.URL,
+		WithHttpReceiverClient(server.Client()),
+		WithHttpReceiverRetry(2, time.Millisecond),
+		WithHttpReceiverRetryForever(),
+		WithHttpReceiverOnRetry(func(attempt int, delay time.Duration, err error) {
+			if err == nil {
+				t.Errorf("OnRetry(%d) called without an error", attempt)
+			}
+			retries = append(retries, attempt)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("CreateHttpReceiver() error = %v", err)
+	}
+	defer receiver.Close()
+
+	if want := []int{1, 2, 3, 4, 5}; !slices.Equal(retries, want) {
+		t.Fatalf("OnRetry attempts = %v, want %v", retries, want)
+	}
+
+	msg, err := receiver.Receive()
+	if err != nil || msg.Data != "finally" {
+		t.Fatalf("Receive() = %#v, %v, want data=finally", msg, err)
+	}
+}
+
+func TestHttpReceiverUnhealthyConnectionsCountAsAttempts(t *testing.T) {
+	t.Parallel()
+
+	var connCount atomic.Int32
+
+	// Every connection is accepted and then dropped straight away.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		connCount.Add(1)
+		w.Header().Set("Content-Type", "text/event-stream")
+	}))
+	defer server.Close()
+
+	var lastRetryErr error
+	receiver, err := CreateHttpReceiver(
+		server.URL,
+		WithHttpReceiverClient(server.Client()),
+		WithHttpReceiverRetry(3, time.Millisecond),
+		WithHttpReceiverHealthyAfter(time.Hour),
+		WithHttpReceiverOnRetry(func(attempt int, delay time.Duration, err error) {
+			lastRetryErr = err
+		}),
+	)
+	if err != nil {
+		t.Fatalf("CreateHttpReceiver() error = %v", err)
+	}
+	defer receiver.Close()
+
+	_, err = receiver.Receive()
+	if err == nil || !strings.Contains(err.Error(), "failed to connect after 3 attempts") {
+		t.Fatalf("Receive() error = %v, want failed to connect after 3 attempts", err)
+	}
+	if !errors.Is(err, io.EOF) || !errors.Is(lastRetryErr, io.EOF) {
+		t.Fatalf("errors = %v / %v, want the stream end as cause", err, lastRetryErr)
+	}
+	if n := connCount.Load(); n != 3 {
+		t.Fatalf("connections = %d, want 3", n)
+	}
+}
+
+func TestHttpReceiverStatusHandling(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		wantConns   int32
+		wantStatus  int
+		wantErr     error
+	}{
+		{name: "204 stops reconnecting", status: http.StatusNoContent, wantConns: 1, wantStatus: http.StatusNoContent},
+		{name: "404 fails fast", status: http.StatusNotFound, body: "no such stream", wantConns: 1, wantStatus: http.StatusNotFound},
+		{name: "401 fails fast", status: http.StatusUnauthorized, wantConns: 1, wantStatus: http.StatusUnauthorized},
+		{name: "408 is retried", status: http.StatusRequestTimeout, wantConns: 3, wantStatus: http.StatusRequestTimeout},
+		{name: "429 is retried", status: http.StatusTooManyRequests, wantConns: 3, wantStatus: http.StatusTooManyRequests},
+		{name: "502 is retried", status: http.StatusBadGateway, body: strings.Repeat("x", 4096), wantConns: 3, wantStatus: http.StatusBadGateway},
+		{name: "html page fails fast", status: http.StatusOK, contentType: "text/html; charset=utf-8", wantConns: 1, wantErr: ErrContentType},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var connCount atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				connCount.Add(1)
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				w.Header().Set("X-Request-Id", "abc")
+				w.WriteHeader(tt.status)
+				_, _ = io.WriteString(w, tt.body)
+			}))
+			defer server.Close()
+
+			_, err := CreateHttpReceiver(
+				server.URL,
+				WithHttpReceiverClient(server.Client()),
+				WithHttpReceiverRetry(3, time.Millisecond),
+			)
+			if err == nil {
+				t.Fatal("CreateHttpReceiver() error = nil")
+			}
+			if n := connCount.Load(); n != tt.wantConns {
+				t.Fatalf("connections = %d, want %d", n, tt.wantConns)
+			}
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+
+			var statusErr *StatusError
+			if !errors.As(err, &statusErr) {
+				t.Fatalf("error = %v, want *StatusError", err)
+			}
+			if statusErr.StatusCode != tt.wantStatus {
+				t.Fatalf("StatusCode = %d, want %d", statusErr.StatusCode, tt.wantStatus)
+			}
+			if statusErr.Header.Get("X-Request-Id") != "abc" {
+				t.Fatalf("Header = %v, want response headers", statusErr.Header)
+			}
+			if len(statusErr.Body) > maxStatusErrorBody || !strings.HasPrefix(tt.body, string(statusErr.Body)) {
+				t.Fatalf("Body = %q, want bounded prefix of %q", statusErr.Body, tt.body)
+			}
+		})
+	}
+}
+
+func TestHttpReceiverPostNonResumable(t *testing.T) {
+	t.Parallel()
+
+	const payload = `{"prompt":"hello"}`
+	var connCount atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		n := connCount.Add(1)
+		body, _ := io.ReadAll(req.Body)
+		if req.Method != http.MethodPost || string(body) != payload {
+			t.Errorf("request #%d = %s %q, want POST %q", n, req.Method, body, payload)
+		}
+		if n == 1 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "data: token\n\n")
+	}))
+	defer server.Close()
+
+	receiver, err := CreateHttpReceiver(
+		server.URL,
+		WithHttpReceiverClient(server.Client()),
+		WithHttpReceiverRetry(3, time.Millisecond),
+		WithHttpReceiverMethod(http.MethodPost),
+		WithHttpReceiverBody(func() (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(payload)), nil
+		}),
+		WithHttpReceiverNonResumable(),
+	)
+	if err != nil {
+		t.Fatalf("CreateHttpReceiver() error = %v", err)
 	}
-	return w.header
-}
+	defer receiver.Close()
 
-func (w *recordingResponseWriter) Write(b []byte) (int, error) {
-	w.mux.Lock()
-	defer w.mux.Unlock()
-	w.writes++
-	if w.writeErr != nil {
-		return 0, w.writeErr
+	msg, err := receiver.Receive()
+	if err != nil || msg.Data != "token" {
+		t.Fatalf("Receive() = %#v, %v, want data=token", msg, err)
+	}
+	for range 2 {
+		if _, err := receiver.Receive(); err != io.EOF {
+			t.Fatalf("Receive() at end of stream error = %v, want %v", err, io.EOF)
+		}
+	}
+	if n := connCount.Load(); n != 2 {
+		t.Fatalf("requests = %d, want 2 (retry before open, none after)", n)
 	}
-	return w.buf.Write(b)
 }
 
-func (w *recordingResponseWriter) WriteHeader(statusCode int) {}
+func TestHttpReceiverNonResumableSentRequest(t *testing.T) {
+	t.Parallel()
 
-func (w *recordingResponseWriter) Flush() {}
+	var connCount atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		connCount.Add(1)
+		_, _ = io.ReadAll(req.Body)
+		// The request arrived, but the connection drops before a response.
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("Hijack() error = %v", err)
+			return
+		}
+		conn.Close()
+	}))
+	defer server.Close()
 
-func (w *recordingResponseWriter) snapshot() (string, int) {
-	w.mux.Lock()
-	defer w.mux.Unlock()
-	return w.buf.String(), w.writes
+	// The first dial fails before anything is sent, which is retried.
+	var dials atomic.Int32
+	transport := server.Client().Transport.(*http.Transport).Clone()
+	dial := transport.DialContext
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if dials.Add(1) == 1 {
+			return nil, errors.New("connection refused")
+		}
+		return dial(ctx, network, addr)
+	}
+
+	_, err := CreateHttpReceiver(
+		server.URL,
+		WithHttpReceiverClient(&http.Client{Transport: transport}),
+		WithHttpReceiverRetry(5, time.Millisecond),
+		WithHttpReceiverMethod(http.MethodPost),
+		WithHttpReceiverBody(func() (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader("start")), nil
+		}),
+		WithHttpReceiverNonResumable(),
+	)
+	if err == nil {
+		t.Fatal("CreateHttpReceiver() error = nil, want the failed request")
+	}
+	if n := connCount.Load(); n != 1 {
+		t.Fatalf("requests = %d, want 1 (a sent request is never sent again)", n)
+	}
+	if n := dials.Load(); n < 2 {
+		t.Fatalf("dials = %d, want the failed dial retried", n)
+	}
 }
 
-func TestHttpPusherPingFiresWithoutPush(t *testing.T) {
+func TestHttpReceiverHeadersAndAuthRefresh(t *testing.T) {
 	t.Parallel()
 
-	w := &recordingResponseWriter{}
-	pusher, err := CreateHttpPusher(w, WithHttpPusherPingDuration(10*time.Millisecond))
+	var connCount atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		connCount.Add(1)
+		if got := req.Header.Get("X-Client"); got != "test" {
+			t.Errorf("X-Client = %q, want %q", got, "test")
+		}
+		if req.Header.Get("Authorization") != "Bearer good" {
+			http.Error(w, "expired", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "data: authorized\n\n")
+	}))
+	defer server.Close()
+
+	var token atomic.Value
+	token.Store("stale")
+	var refreshes atomic.Int32
+
+	receiver, err := CreateHttpReceiver(
+		server.URL,
+		WithHttpReceiverClient(server.Client()),
+		WithHttpReceiverRetry(1, 0),
+		WithHttpReceiverHeader("X-Client", "test"),
+		WithHttpReceiverBeforeRequest(func(req *http.Request) error {
+			req.Header.Set("Authorization", "Bearer "+token.Load().(string))
+			return nil
+		}),
+		WithHttpReceiverRefreshAuth(func(ctx context.Context) error {
+			refreshes.Add(1)
+			token.Store("good")
+			return nil
+		}),
+	)
 	if err != nil {
-		t.Fatalf("CreateHttpPusher() error = %v", err)
+		t.Fatalf("CreateHttpReceiver() error = %v", err)
 	}
-	defer pusher.Close()
+	defer receiver.Close()
 
-	deadline := time.Now().Add(time.Second)
-	for {
-		out, _ := w.snapshot()
-		if strings.Contains(out, ": ping\n\n") {
-			break
-		}
-		if time.Now().After(deadline) {
-			t.Fatalf("ping was never written without an initial Push, got %q", out)
-		}
-		time.Sleep(5 * time.Millisecond)
+	msg, err := receiver.Receive()
+	if err != nil || msg.Data != "authorized" {
+		t.Fatalf("Receive() = %#v, %v, want data=authorized", msg, err)
+	}
+	if n, r := connCount.Load(), refreshes.Load(); n != 2 || r != 1 {
+		t.Fatalf("connections = %d, refreshes = %d, want 2 and 1", n, r)
 	}
 }
 
-func TestHttpPusherPingStopsAfterClose(t *testing.T) {
+func TestHttpReceiverAuthRefreshOnlyOnce(t *testing.T) {
 	t.Parallel()
 
-	w := &recordingResponseWriter{}
-	pusher, err := CreateHttpPusher(w, WithHttpPusherPingDuration(10*time.Millisecond))
-	if err != nil {
-		t.Fatalf("CreateHttpPusher() error = %v", err)
-	}
+	var connCount atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		connCount.Add(1)
+		http.Error(w, "denied", http.StatusUnauthorized)
+	}))
+	defer server.Close()
 
-	if err := pusher.Close(); err != nil {
-		t.Fatalf("Close() error = %v", err)
-	}
+	var refreshes atomic.Int32
+	_, err := CreateHttpReceiver(
+		server.URL,
+		WithHttpReceiverClient(server.Client()),
+		WithHttpReceiverRetry(3, time.Millisecond),
+		WithHttpReceiverRefreshAuth(func(ctx context.Context) error {
+			refreshes.Add(1)
+			return nil
+		}),
+	)
 
-	time.Sleep(50 * time.Millisecond)
-	_, before := w.snapshot()
-	time.Sleep(50 * time.Millisecond)
-	_, after := w.snapshot()
-	if after != before {
-		t.Fatalf("pings kept firing after Close(): writes %d -> %d", before, after)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("CreateHttpReceiver() error = %v, want 401 StatusError", err)
+	}
+	if n, r := connCount.Load(), refreshes.Load(); n != 2 || r != 1 {
+		t.Fatalf("connections = %d, refreshes = %d, want 2 and 1", n, r)
 	}
 }
 
-func TestHttpPusherPingStopsAfterWriteError(t *testing.T) {
+func TestParseRetryAfter(t *testing.T) {
 	t.Parallel()
 
-	w := &recordingResponseWriter{writeErr: io.ErrClosedPipe}
-	pusher, err := CreateHttpPusher(w, WithHttpPusherPingDuration(10*time.Millisecond))
-	if err != nil {
-		t.Fatalf("CreateHttpPusher() error = %v", err)
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{value: "", want: 0},
+		{value: "120", want: 2 * time.Minute},
+		{value: "0", want: 0},
+		{value: "-5", want: 0},
+		{value: "soon", want: 0},
+		{value: now.Add(90 * time.Second).Format(http.TimeFormat), want: 90 * time.Second},
+		{value: now.Add(-time.Hour).Format(http.TimeFormat), want: 0},
 	}
-	defer pusher.Close()
 
-	deadline := time.Now().Add(time.Second)
-	for {
-		if _, writes := w.snapshot(); writes >= 1 {
-			break
-		}
-		if time.Now().After(deadline) {
-			t.Fatal("first ping never attempted")
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
 		}
-		time.Sleep(5 * time.Millisecond)
-	}
-
-	time.Sleep(100 * time.Millisecond)
-	if _, writes := w.snapshot(); writes > 1 {
-		t.Fatalf("ping chain kept re-arming after write error: %d write attempts", writes)
 	}
 }
 
-func TestHttpReceiverCloseClosesUnderlyingBody(t *testing.T) {
+func TestHttpReceiverHonorsRetryAfter(t *testing.T) {
 	t.Parallel()
 
-	body := &closeTrackingReadCloser{}
-	client := &http.Client{
-		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
-			return &http.Response{
-				StatusCode: http.StatusOK,
-				Header:     make(http.Header),
-				Body:       body,
-			}, nil
-		}),
-	}
+	var connCount atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if connCount.Add(1) == 1 {
+			w.Header().Set("Retry-After", "3600")
+			http.Error(w, "shedding load", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+	}))
+	defer server.Close()
 
-	receiver, err := CreateHttpReceiver("http://example.com/sse", WithHttpReceiverClient(client))
+	var gotDelay time.Duration
+	var gotErr error
+	receiver, err := CreateHttpReceiver(
+		server.URL,
+		WithHttpReceiverClient(server.Client()),
+		WithHttpReceiverRetry(2, time.Hour),
+		WithHttpReceiverMaxRetryAfter(10*time.Millisecond),
+		WithHttpReceiverOnRetry(func(attempt int, delay time.Duration, err error) {
+			gotDelay, gotErr = delay, err
+		}),
+	)
 	if err != nil {
 		t.Fatalf("CreateHttpReceiver() error = %v", err)
 	}
+	defer receiver.Close()
 
-	if err := receiver.Close(); err != nil {
-		t.Fatalf("Close() error = %v", err)
+	if gotDelay != 10*time.Millisecond {
+		t.Fatalf("OnRetry delay = %v, want Retry-After capped to %v", gotDelay, 10*time.Millisecond)
 	}
-	if !body.closed.Load() {
-		t.Fatal("Close() did not close underlying body")
-	}
-
-	if err := receiver.Close(); !errors.Is(err, http.ErrServerClosed) {
-		t.Fatalf("second Close() error = %v, want %v", err, http.ErrServerClosed)
+	var statusErr *StatusError
+	if !errors.As(gotErr, &statusErr) || statusErr.RetryAfter != time.Hour {
+		t.Fatalf("OnRetry error = %v, want StatusError with RetryAfter 1h", gotErr)
 	}
 }
 
-func TestHttpReceiverReceiveReturnsErrorWhenReconnectFails(t *testing.T) {
+func TestHttpReceiverStopsOnNoContent(t *testing.T) {
 	t.Parallel()
 
 	var connCount atomic.Int32
-
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		w.Header().Set("Content-Type", "text/event-stream")
 		if connCount.Add(1) == 1 {
-			_, _ = io.WriteString(w, "id: 1\ndata: first\n\n")
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = io.WriteString(w, "data: only\n\n")
 			return
 		}
-		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		w.WriteHeader(http.StatusNoContent)
 	}))
-
-	client := server.Client()
-	url := server.URL
+	defer server.Close()
 
 	receiver, err := CreateHttpReceiver(
-		url,
-		WithHttpReceiverClient(client),
-		WithHttpReceiverRetry(2, 10*time.Millisecond),
+		server.URL,
+		WithHttpReceiverClient(server.Client()),
+		WithHttpReceiverRetry(3, time.Millisecond),
 	)
 	if err != nil {
 		t.Fatalf("CreateHttpReceiver() error = %v", err)
 	}
+	defer receiver.Close()
 
-	msg, err := receiver.Receive()
-	if err != nil {
+	if _, err := receiver.Receive(); err != nil {
 		t.Fatalf("first Receive() error = %v", err)
 	}
-	if msg.Id != "1" || msg.Data != "first" {
-		t.Fatalf("first Receive() = %#v, want id=1 data=first", msg)
+	for range 2 {
+		var statusErr *StatusError
+		if _, err := receiver.Receive(); !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNoContent {
+			t.Fatalf("Receive() error = %v, want 204 StatusError", err)
+		}
 	}
+	if n := connCount.Load(); n != 2 {
+		t.Fatalf("connections = %d, want 2 (no reconnects after 204)", n)
+	}
+}
 
-	server.Close()
+func TestHttpReceiverLifecycleHooks(t *testing.T) {
+	t.Parallel()
 
-	_, err = receiver.Receive()
-	if err == nil {
-		t.Fatal("second Receive() expected reconnect failure, got nil")
+	var connCount atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch connCount.Add(1) {
+		case 1:
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		case 2:
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = io.WriteString(w, "data: one\n\n")
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	var (
+		mu     sync.Mutex
+		events []string
+	)
+	record := func(format string, args ...any) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, fmt.Sprintf(format, args...))
 	}
-	if !strings.Contains(err.Error(), "failed to connect") {
-		t.Fatalf("second Receive() error = %v, want failed to connect", err)
+
+	receiver, err := CreateHttpReceiver(
+		server.URL,
+		WithHttpReceiverClient(server.Client()),
+		WithHttpReceiverRetry(3, 0),
+		WithHttpReceiverOnConnecting(func(attempt int) {
+			record("connecting %d", attempt)
+		}),
+		WithHttpReceiverOnOpen(func(resp *http.Response) {
+			record("open %d", resp.StatusCode)
+		}),
+		WithHttpReceiverOnRetry(func(attempt int, delay time.Duration, err error) {
+			record("retry %d", attempt)
+		}),
+		WithHttpReceiverOnDisconnect(func(cause error) {
+			record("disconnect %v", cause)
+		}),
+		WithHttpReceiverOnClosed(func(err error) {
+			var statusErr *StatusError
+			errors.As(err, &statusErr)
+			record("closed %d", statusErr.StatusCode)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("CreateHttpReceiver() error = %v", err)
 	}
 
+	if _, err := receiver.Receive(); err != nil {
+		t.Fatalf("first Receive() error = %v", err)
+	}
+	if _, err := receiver.Receive(); err == nil {
+		t.Fatal("second Receive() error = nil, want 204 StatusError")
+	}
 	_ = receiver.Close()
+
+	want := []string{
+		"connecting 1",
+		"retry 1",
+		"connecting 2",
+		"open 200",
+		"disconnect EOF",
+		"retry 0",
+		"connecting 1",
+		"closed 204",
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Equal(events, want) {
+		t.Fatalf("hook calls = %q, want %q", events, want)
+	}
 }
 
 func TestThroughputMessagesPerSecond(t *testing.T) {
@@ -774,6 +2145,24 @@ func BenchmarkReadMessageLargeData(b *testing.B) {
 	}
 }
 
+func BenchmarkDecoderDecode(b *testing.B) {
+	input := "id: 42\nevent: tick\ndata: hello\n\n"
+	r := strings.NewReader(input)
+	dec := NewDecoder(r)
+	var msg Message
+	b.ReportAllocs()
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+
+	for b.Loop() {
+		r.Reset(input)
+		dec.Reset(r)
+		if err := dec.Decode(&msg); err != nil {
+			b.Fatalf("Decode() error = %v", err)
+		}
+	}
+}
+
 func BenchmarkWriteMessageSmall(b *testing.B) {
 	msg := &Message{Id: "42", Event: "tick", Data: "hello"}
 	var out bytes.Buffer
@@ -917,6 +2306,55 @@ func BenchmarkHttpReceiverReceiveSteadyState(b *testing.B) {
 	}
 }
 
+// preEncodedReceiver returns a receiver connected to a server streaming
+// pre-encoded messages, so allocations counted are the receiver's own.
+func preEncodedReceiver(b *testing.B) *HttpReceiver {
+	var chunk bytes.Buffer
+	for i := range 1000 {
+		fmt.Fprintf(&chunk, "id: %d\ndata: benchmark\n\n", 100000+i)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for {
+			if _, err := w.Write(chunk.Bytes()); err != nil {
+				return
+			}
+		}
+	}))
+	b.Cleanup(server.Close)
+
+	receiver, err := CreateHttpReceiver(
+		server.URL,
+		WithHttpReceiverClient(server.Client()),
+		WithHttpReceiverRetry(1, 0),
+	)
+	if err != nil {
+		b.Fatalf("CreateHttpReceiver() error = %v", err)
+	}
+	b.Cleanup(func() {
+		_ = receiver.Close()
+	})
+	return receiver
+}
+
+func BenchmarkHttpReceiverReceiveIntoSteadyState(b *testing.B) {
+	receiver := preEncodedReceiver(b)
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len("id: 100000\ndata: benchmark\n\n")))
+	b.ResetTimer()
+
+	var msg Message
+	for b.Loop() {
+		if err := receiver.ReceiveInto(ctx, &msg); err != nil {
+			b.Fatalf("ReceiveInto() error = %v", err)
+		}
+	}
+	benchReadSink = &msg
+}
+
 func BenchmarkHttpReceiverReceiveReconnect(b *testing.B) {
 	var connCount atomic.Int64
 
@@ -0,0 +1,133 @@
+package sse
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrReplayGap is returned by EventStore.ReplayAfter when the requested id
+// is not in the store, usually because it was evicted, so some events after
+// it can no longer be replayed.
+var ErrReplayGap = errors.New("sse: replay history has a gap")
+
+// EventStore keeps recent messages so that clients reconnecting with a
+// Last-Event-ID can be sent what they missed. Implementations must be safe
+// for concurrent use.
+type EventStore interface {
+	// Append stores a copy of msg. If msg has no Id, Append assigns one,
+	// unique within the store and increasing in append order, and sets it
+	// on msg.
+	Append(msg *Message) error
+	// ReplayAfter calls fn, oldest first, with every stored message
+	// appended after the one with id lastID, stopping at the first error
+	// fn returns. An empty lastID replays everything stored. If lastID is
+	// not in the store, ReplayAfter calls nothing and returns ErrReplayGap.
+	ReplayAfter(lastID string, fn func(msg *Message) error) error
+}
+
+type memoryEntry struct {
+	seq uint64
+	at  time.Time
+	msg Message
+}
+
+type memoryStore struct {
+	mu      sync.Mutex
+	entries []memoryEntry // ring buffer of up to cap(entries) messages
+	head    int           // index of the oldest entry
+	size    int
+	seq     uint64            // sequence number of the last append
+	index   map[string]uint64 // id to sequence number of stored entries
+	maxAge  time.Duration
+	now     func() time.Time
+}
+
+// NewMemoryStore returns an EventStore keeping the latest maxEvents messages
+// in memory, and none older than maxAge. A maxAge of zero means messages are
+// only evicted by count. Ids it assigns are decimal sequence numbers.
+func NewMemoryStore(maxEvents int, maxAge time.Duration) EventStore {
+	return &memoryStore{
+		entries: make([]memoryEntry, max(maxEvents, 1)),
+		index:   make(map[string]uint64),
+		maxAge:  maxAge,
+		now:     time.Now,
+	}
+}
+
+func (s *memoryStore) Append(msg *Message) error {
+	if err := validateMessage(msg); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.expire(now)
+	if s.size == len(s.entries) {
+		s.evict()
+	}
+
+	s.seq++
+	if msg.Id == "" {
+		msg.Id = strconv.FormatUint(s.seq, 10)
+	}
+	s.entries[(s.head+s.size)%len(s.entries)] = memoryEntry{seq: s.seq, at: now, msg: *msg}
+	s.size++
+	s.index[msg.Id] = s.seq
+	return nil
+}
+
+// expire evicts entries older than maxAge.
+func (s *memoryStore) expire(now time.Time) {
+	if s.maxAge <= 0 {
+		return
+	}
+	for s.size > 0 && now.Sub(s.entries[s.head].at) > s.maxAge {
+		s.evict()
+	}
+}
+
+// evict drops the oldest entry.
+func (s *memoryStore) evict() {
+	e := &s.entries[s.head]
+	// A later message may have reused the id.
+	if s.index[e.msg.Id] == e.seq {
+		delete(s.index, e.msg.Id)
+	}
+	*e = memoryEntry{}
+	s.head = (s.head + 1) % len(s.entries)
+	s.size--
+}
+
+func (s *memoryStore) ReplayAfter(lastID string, fn func(msg *Message) error) error {
+	s.mu.Lock()
+	s.expire(s.now())
+
+	start := 0
+	if lastID != "" {
+		seq, ok := s.index[lastID]
+		if !ok {
+			s.mu.Unlock()
+			return ErrReplayGap
+		}
+		start = int(seq - s.entries[s.head].seq + 1)
+	}
+
+	// Copy out so fn, which typically writes to the network, runs without
+	// holding up appends.
+	msgs := make([]Message, 0, s.size-start)
+	for i := start; i < s.size; i++ {
+		msgs = append(msgs, s.entries[(s.head+i)%len(s.entries)].msg)
+	}
+	s.mu.Unlock()
+
+	for i := range msgs {
+		if err := fn(&msgs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
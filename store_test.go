@@ -0,0 +1,95 @@
+package sse
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func replayIDs(t *testing.T, s EventStore, lastID string) ([]string, error) {
+	t.Helper()
+
+	var ids []string
+	err := s.ReplayAfter(lastID, func(msg *Message) error {
+		ids = append(ids, msg.Id)
+		return nil
+	})
+	return ids, err
+}
+
+func TestMemoryStore(t *testing.T) {
+	t.Parallel()
+
+	s := NewMemoryStore(3, 0)
+	for range 4 {
+		if err := s.Append(&Message{Data: "x"}); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+	custom := &Message{Id: "custom", Data: "y"}
+	if err := s.Append(custom); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	if custom.Id != "custom" {
+		t.Fatalf("Append() changed Id to %q", custom.Id)
+	}
+	assigned := &Message{Data: "z"}
+	if err := s.Append(assigned); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	if assigned.Id != "6" {
+		t.Fatalf("Append() assigned Id %q, want %q", assigned.Id, "6")
+	}
+
+	tests := []struct {
+		lastID  string
+		want    []string
+		wantErr error
+	}{
+		{lastID: "", want: []string{"4", "custom", "6"}},
+		{lastID: "4", want: []string{"custom", "6"}},
+		{lastID: "custom", want: []string{"6"}},
+		{lastID: "6", want: nil},
+		{lastID: "3", wantErr: ErrReplayGap},
+		{lastID: "unknown", wantErr: ErrReplayGap},
+	}
+	for _, tt := range tests {
+		got, err := replayIDs(t, s, tt.lastID)
+		if !errors.Is(err, tt.wantErr) {
+			t.Fatalf("ReplayAfter(%q) error = %v, want %v", tt.lastID, err, tt.wantErr)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Fatalf("ReplayAfter(%q) replayed %q, want %q", tt.lastID, got, tt.want)
+		}
+	}
+
+	if err := s.Append(&Message{Id: "bad\nid"}); !errors.Is(err, ErrInvalidField) {
+		t.Fatalf("Append() error = %v, want %v", err, ErrInvalidField)
+	}
+}
+
+func TestMemoryStoreMaxAge(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1000, 0)
+	s := NewMemoryStore(100, time.Minute).(*memoryStore)
+	s.now = func() time.Time { return now }
+
+	for range 3 {
+		if err := s.Append(&Message{Data: "x"}); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+		now = now.Add(40 * time.Second)
+	}
+
+	// The first message is now two minutes old, the second 80s, the
+	// third 40s.
+	got, err := replayIDs(t, s, "")
+	if err != nil || !slices.Equal(got, []string{"3"}) {
+		t.Fatalf("ReplayAfter(\"\") = %q, %v, want [3]", got, err)
+	}
+	if _, err := replayIDs(t, s, "2"); !errors.Is(err, ErrReplayGap) {
+		t.Fatalf("ReplayAfter(2) error = %v, want %v", err, ErrReplayGap)
+	}
+}